@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/core/types"
+)
+
+// SupplyResponse is the response type for [GET] /supply. The field names
+// match those expected by CoinMarketCap. The supplies and the siafund pool
+// are float64 numbers of siacoins, or exact decimal strings if requested with
+// exact=true.
+type SupplyResponse struct {
+	Index             types.ChainIndex `json:"index"`
+	TotalSupply       any              `json:"total_supply"`       //nolint:tagliatelle
+	CirculatingSupply any              `json:"circulating_supply"` //nolint:tagliatelle
+	SiafundPool       any              `json:"siafund_pool"`       //nolint:tagliatelle
+	MaxSupply         *float64         `json:"max_supply"`         //nolint:tagliatelle
+	LastUpdated       time.Time        `json:"last_updated"`       //nolint:tagliatelle
+}
+
+// CoinGeckoSupplyResponse is the response type for [GET] /coingecko/supply.
+// The field names match those expected by CoinGecko. The supplies are encoded
+// the same way as in SupplyResponse.
+type CoinGeckoSupplyResponse struct {
+	CirculatingSupply any `json:"circulating_supply"` //nolint:tagliatelle
+	TotalSupply       any `json:"total_supply"`       //nolint:tagliatelle
+}
+
+// CMCSupplyResponse is the response type for [GET] /cmc/supply. It only
+// contains the fields CoinMarketCap ingests, and the supplies are always
+// numbers of siacoins, regardless of the exact query parameter or the server
+// default.
+type CMCSupplyResponse struct {
+	CirculatingSupply float64 `json:"circulating_supply"` //nolint:tagliatelle
+	TotalSupply       float64 `json:"total_supply"`       //nolint:tagliatelle
+}
+
+// ValueResponse is the response type for supply values requested with
+// meta=true. Value is a number of siacoins or a string of hastings depending
+// on the requested units. Siacoins are a string if exact or decimals is set.
+type ValueResponse struct {
+	Value   any           `json:"value"`
+	Height  uint64        `json:"height"`
+	BlockID types.BlockID `json:"block_id"` //nolint:tagliatelle
+}
+
+// VersionResponse is the response type for [GET] /version
+type VersionResponse struct {
+	Version        string           `json:"version"`
+	Commit         string           `json:"commit"`
+	BuildTime      time.Time        `json:"build_time"`      //nolint:tagliatelle
+	WalletdAddress string           `json:"walletd_address"` //nolint:tagliatelle
+	Index          types.ChainIndex `json:"index"`
+}
+
+// TipResponse is the response type for [GET] /tip. ChainTip is the tip of
+// the walletd node the index is synced with. Synced is set if the index is at
+// most the configured maximum health lag behind it.
+type TipResponse struct {
+	Index        types.ChainIndex `json:"index"`
+	ChainTip     types.ChainIndex `json:"chain_tip"`     //nolint:tagliatelle
+	Synced       bool             `json:"synced"`        //nolint:tagliatelle
+	BlocksBehind uint64           `json:"blocks_behind"` //nolint:tagliatelle
+}
+
+// SyncProgressResponse is the response type for [GET] /sync/progress.
+// Percent is the percentage of walletd's chain that has been indexed.
+type SyncProgressResponse struct {
+	Height  uint64  `json:"height"`
+	Tip     uint64  `json:"tip"`
+	Percent float64 `json:"percent"`
+}
+
+// TxpoolSupplyResponse is the response type for [GET] /txpool/supply.
+// Burned is the value the unconfirmed transactions send to the void address,
+// which would be subtracted from the total supply if they were confirmed.
+type TxpoolSupplyResponse struct {
+	Transactions int            `json:"transactions"`
+	Burned       types.Currency `json:"burned"`
+}
+
+// SupplyUpdate is the message type of [GET] /ws/supply. One is sent when
+// the client connects and each time a new block is indexed. The circulating
+// supply excludes the foundation treasury.
+type SupplyUpdate struct {
+	Index             types.ChainIndex `json:"index"`
+	Timestamp         time.Time        `json:"timestamp"`
+	TotalSupply       types.Currency   `json:"total_supply"`       //nolint:tagliatelle
+	CirculatingSupply types.Currency   `json:"circulating_supply"` //nolint:tagliatelle
+	BurnedSupply      types.Currency   `json:"burned_supply"`      //nolint:tagliatelle
+}
+
+// ErrorResponse is the response body for all API errors.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// HealthResponse is the response type for [GET] /health. Stalled is set if
+// no new block has been indexed for longer than the stall threshold, and
+// SinceLastBlock is the time since the index last advanced. Both are only
+// reported if the server has a StallMonitor.
+type HealthResponse struct {
+	Synced         bool          `json:"synced"`
+	Lag            uint64        `json:"lag"`
+	Stalled        bool          `json:"stalled"`
+	SinceLastBlock time.Duration `json:"since_last_block,omitempty"` //nolint:tagliatelle
+}
+
+// SupplyHistoryResponse is the response type for [GET] /supply/history. The
+// circulating supply includes the foundation treasury.
+type SupplyHistoryResponse struct {
+	Index             types.ChainIndex `json:"index"`
+	Timestamp         time.Time        `json:"timestamp"`
+	TotalSupply       types.Currency   `json:"total_supply"`       //nolint:tagliatelle
+	CirculatingSupply types.Currency   `json:"circulating_supply"` //nolint:tagliatelle
+	BurnedSupply      types.Currency   `json:"burned_supply"`      //nolint:tagliatelle
+}
+
+// A BurnedSupplyPoint is the burned supply in hastings after the block at
+// Height was applied.
+type BurnedSupplyPoint struct {
+	Height       uint64         `json:"height"`
+	BurnedSupply types.Currency `json:"burned_supply"` //nolint:tagliatelle
+}
+
+// BurnedHistoryResponse is the response type for [GET]
+// /supply/burned/history. Step is the number of blocks between points, which
+// is larger than requested if the range would return too many points.
+type BurnedHistoryResponse struct {
+	Step   uint64              `json:"step"`
+	Points []BurnedSupplyPoint `json:"points"`
+}
+
+// EmissionResponse is the response type for [GET] /supply/emission. Emission
+// is the change in the total supply between the start and end heights in
+// hastings, and EmissionSC is the same value in siacoins. It is negative if
+// more was burned than minted. Partial is set if the indexed history does not
+// extend back the full window.
+type EmissionResponse struct {
+	Window      uint64 `json:"window"`
+	Emission    string `json:"emission"`
+	EmissionSC  any    `json:"emission_sc"`  //nolint:tagliatelle
+	StartHeight uint64 `json:"start_height"` //nolint:tagliatelle
+	EndHeight   uint64 `json:"end_height"`   //nolint:tagliatelle
+	Partial     bool   `json:"partial"`
+}
+
+// InflationResponse is the response type for [GET] /supply/inflation. Rate
+// is the growth of the total supply between the start and end heights as a
+// fraction of the total supply at the start height. Partial is set if the
+// indexed history does not extend back a full year.
+type InflationResponse struct {
+	Rate           float64   `json:"rate"`
+	StartHeight    uint64    `json:"start_height"`    //nolint:tagliatelle
+	StartTimestamp time.Time `json:"start_timestamp"` //nolint:tagliatelle
+	EndHeight      uint64    `json:"end_height"`      //nolint:tagliatelle
+	EndTimestamp   time.Time `json:"end_timestamp"`   //nolint:tagliatelle
+	Partial        bool      `json:"partial"`
+}
+
+// RichListResponse is the response type for [GET] /addresses/rich.
+// NextCursor is set if the page is full and may be followed by more
+// addresses. Pass it as the cursor query parameter to get the next page.
+type RichListResponse struct {
+	Addresses  []index.AddressBalance `json:"addresses"`
+	NextCursor string                 `json:"next_cursor,omitempty"` //nolint:tagliatelle
+}
+
+// AddressBalanceResponse is the response type for [GET] /addresses/:address
+type AddressBalanceResponse struct {
+	Address    types.Address  `json:"address"`
+	Balance    types.Currency `json:"balance"`
+	Foundation bool           `json:"foundation"`
+}
+
+// MaintenanceResponse is the response type for [POST] /admin/vacuum and
+// [POST] /admin/backup. Path is only set for backups.
+type MaintenanceResponse struct {
+	Path     string        `json:"path,omitempty"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AuditResponse is the response type for [GET] /admin/audit. Difference is
+// the address balances minus the circulating supply in hastings, and is
+// negative if the balances are less than the supply.
+type AuditResponse struct {
+	Index             types.ChainIndex `json:"index"`
+	CirculatingSupply types.Currency   `json:"circulating_supply"` //nolint:tagliatelle
+	AddressBalances   types.Currency   `json:"address_balances"`   //nolint:tagliatelle
+	Difference        string           `json:"difference"`
+	Consistent        bool             `json:"consistent"`
+}
+
+// ExclusionListResponse is the response type for [POST]
+// /admin/exclusions/reload.
+type ExclusionListResponse struct {
+	Addresses []types.Address `json:"addresses"`
+}
+
+// parseAddress parses a hex encoded address with its checksum. The "addr:"
+// prefix used by older Sia software is accepted. The errors describe what is
+// wrong with the address so they can be returned to clients.
+func parseAddress(s string) (types.Address, error) {
+	const encodedLen = 2 * (32 + 6) // address and checksum
+	s = strings.TrimPrefix(s, "addr:")
+	if len(s) != encodedLen {
+		return types.Address{}, fmt.Errorf("address must be %d hex characters, got %d", encodedLen, len(s))
+	}
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Address{}, errors.New("address must be hex encoded")
+	}
+	var addr types.Address
+	copy(addr[:], buf)
+	if checksum := types.HashBytes(addr[:]); !bytes.Equal(checksum[:6], buf[32:]) {
+		return types.Address{}, errors.New("address has an invalid checksum, check that it was copied correctly")
+	}
+	return addr, nil
+}
+
+// A RichListCursor identifies the last address of a page of [GET]
+// /addresses/rich. Passing it as the cursor query parameter returns the next
+// page. It is encoded as "<balance in hastings>-<address>".
+type RichListCursor struct {
+	Balance types.Currency
+	Address types.Address
+}
+
+// String implements fmt.Stringer.
+func (c RichListCursor) String() string {
+	return c.Balance.ExactString() + "-" + c.Address.String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c RichListCursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *RichListCursor) UnmarshalText(b []byte) error {
+	balance, address, ok := strings.Cut(string(b), "-")
+	if !ok {
+		return errors.New("missing separator")
+	} else if err := c.Balance.UnmarshalText([]byte(balance)); err != nil {
+		return fmt.Errorf("invalid balance: %w", err)
+	} else if c.Address, err = parseAddress(address); err != nil {
+		return err
+	}
+	return nil
+}
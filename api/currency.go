@@ -0,0 +1,49 @@
+package api
+
+import (
+	"github.com/shopspring/decimal"
+	"go.sia.tech/core/types"
+)
+
+// scDecimals is the number of decimal places of a siacoin, 1 SC = 10^24 H.
+const scDecimals = 24
+
+// hastingsToSC converts c from hastings to a float64 number of siacoins. The
+// result is rounded to the nearest float64.
+func hastingsToSC(c types.Currency) float64 {
+	return decimal.NewFromBigInt(c.Big(), -scDecimals).InexactFloat64()
+}
+
+// hastingsToSCString converts c from hastings to a number of siacoins with
+// exactly places decimal places, rounding half away from zero.
+func hastingsToSCString(c types.Currency, places int32) string {
+	return decimal.NewFromBigInt(c.Big(), -scDecimals).StringFixed(places)
+}
+
+// hastingsToSCExact converts c from hastings to the exact decimal number of
+// siacoins, without trailing zeros.
+func hastingsToSCExact(c types.Currency) string {
+	return decimal.NewFromBigInt(c.Big(), -scDecimals).String()
+}
+
+// scValue returns c in siacoins. If exact is set, the value is the exact
+// decimal string. Otherwise, it is rounded to the nearest float64.
+func scValue(c types.Currency, exact bool) any {
+	if exact {
+		return hastingsToSCExact(c)
+	}
+	return hastingsToSC(c)
+}
+
+// signedSCValue is like scValue, but returns the negative of c if negative is
+// set.
+func signedSCValue(c types.Currency, negative, exact bool) any {
+	switch {
+	case negative && exact:
+		return "-" + hastingsToSCExact(c)
+	case negative:
+		return -hastingsToSC(c)
+	default:
+		return scValue(c, exact)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestHastingsToSC(t *testing.T) {
+	maxCurrency := types.NewCurrency(^uint64(0), ^uint64(0))
+	tests := []struct {
+		c      types.Currency
+		sc     float64
+		places int32
+		str    string
+	}{
+		{types.ZeroCurrency, 0, 2, "0.00"},
+		{types.NewCurrency64(1), 1e-24, 24, "0.000000000000000000000001"},
+		{types.NewCurrency64(1), 1e-24, 23, "0.00000000000000000000000"},
+		{types.Siacoins(1).Div64(2), 0.5, 0, "1"}, // rounds half away from zero
+		{types.Siacoins(1).Div64(3), 1.0 / 3, 3, "0.333"},
+		{types.Siacoins(57).Mul64(1e9), 57e9, 1, "57000000000.0"},
+		// the largest currency value, 2^128-1 hastings, is far from float64
+		// overflow, but loses precision as a float
+		{maxCurrency, 340282366920938.463463374607431768211455, 24, "340282366920938.463463374607431768211455"},
+	}
+	for _, test := range tests {
+		if sc := hastingsToSC(test.c); sc != test.sc {
+			t.Errorf("hastingsToSC(%v): expected %v, got %v", test.c.ExactString(), test.sc, sc)
+		}
+		if str := hastingsToSCString(test.c, test.places); str != test.str {
+			t.Errorf("hastingsToSCString(%v, %d): expected %q, got %q", test.c.ExactString(), test.places, test.str, str)
+		}
+	}
+}
+
+func TestHastingsToSCExact(t *testing.T) {
+	const exact = "123456789.123456789012345678901234"
+	c, err := types.ParseCurrency(exact + " SC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the float loses everything past the 8th decimal place
+	if str := hastingsToSCExact(c); str != exact {
+		t.Fatalf("expected %q, got %q", exact, str)
+	} else if sc := hastingsToSC(c); sc != 123456789.12345679 {
+		t.Fatalf("expected %v, got %v", 123456789.12345679, sc)
+	} else if v := scValue(c, true); v != exact {
+		t.Fatalf("expected exact value %q, got %v", exact, v)
+	} else if v := scValue(c, false); v != hastingsToSC(c) {
+		t.Fatalf("expected float value %v, got %v", hastingsToSC(c), v)
+	}
+
+	// trailing zeros are removed
+	if str := hastingsToSCExact(types.Siacoins(100)); str != "100" {
+		t.Fatalf("expected %q, got %q", "100", str)
+	}
+
+	// negative values follow the same rules
+	if v := signedSCValue(c, true, true); v != "-"+exact {
+		t.Fatalf("expected exact value %q, got %v", "-"+exact, v)
+	} else if v := signedSCValue(c, true, false); v != -hastingsToSC(c) {
+		t.Fatalf("expected float value %v, got %v", -hastingsToSC(c), v)
+	} else if v := signedSCValue(c, false, true); v != exact {
+		t.Fatalf("expected exact value %q, got %v", exact, v)
+	}
+}
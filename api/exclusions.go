@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.sia.tech/core/types"
+)
+
+// An ExclusionList is a set of addresses whose balances are excluded from
+// the circulating supply in addition to the foundation treasury, e.g. known
+// burn or escrow addresses. The addresses are read from a fixed list and an
+// optional file. The file is read again by Reload, so the list can be changed
+// without restarting the server.
+type ExclusionList struct {
+	path  string
+	fixed []types.Address
+
+	mu    sync.Mutex
+	addrs []types.Address
+}
+
+// Addresses returns the excluded addresses.
+func (l *ExclusionList) Addresses() []types.Address {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]types.Address(nil), l.addrs...)
+}
+
+// Reload reads the exclusion file again and returns the new list of excluded
+// addresses. If the file can't be read, the current list is kept.
+func (l *ExclusionList) Reload() ([]types.Address, error) {
+	addrs := append([]types.Address(nil), l.fixed...)
+	if l.path != "" {
+		fileAddrs, err := readExclusionFile(l.path)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, fileAddrs...)
+	}
+
+	// remove duplicates so their balances are not subtracted twice
+	seen := make(map[types.Address]bool)
+	unique := addrs[:0]
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			unique = append(unique, addr)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addrs = unique
+	return append([]types.Address(nil), unique...), nil
+}
+
+// readExclusionFile reads one address per line from path. Blank lines and
+// lines starting with # are ignored.
+func readExclusionFile(path string) ([]types.Address, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exclusion file: %w", err)
+	}
+	defer f.Close()
+
+	var addrs []types.Address
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addr, err := parseAddress(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read exclusion file: %w", err)
+	}
+	return addrs, nil
+}
+
+// NewExclusionList returns an ExclusionList containing addrs and the
+// addresses in the file at path. If path is empty, only addrs are excluded.
+func NewExclusionList(addrs []string, path string) (*ExclusionList, error) {
+	l := &ExclusionList{path: path}
+	for _, s := range addrs {
+		addr, err := parseAddress(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		l.fixed = append(l.fixed, addr)
+	}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+)
+
+// Response formats selected with the "format" query parameter
+const (
+	formatJSON       = "json"
+	formatCSV        = "csv"
+	formatPrometheus = "prometheus"
+)
+
+// A field is a named value of a response. Fields are written as CSV columns
+// or Prometheus gauges.
+type field struct {
+	Name  string
+	Help  string
+	Value any
+}
+
+// decodeFormat decodes the "format" query parameter, writing a 400 if it is
+// unknown. JSON is used if no format is specified.
+func decodeFormat(jc jape.Context) (string, bool) {
+	format := formatJSON
+	if jc.DecodeForm("format", &format) != nil {
+		return "", false
+	}
+	switch format {
+	case formatJSON, formatCSV, formatPrometheus:
+		return format, true
+	default:
+		jc.Error(fmt.Errorf("unknown format %q", format), http.StatusBadRequest)
+		return "", false
+	}
+}
+
+// writeFormatted writes a response in format. JSON encodes v. CSV writes a
+// header of the field names followed by one row per record. Prometheus writes
+// the numeric fields of a single record as gauges prefixed with "sia_", with
+// timestamps as Unix seconds.
+func writeFormatted(jc jape.Context, format string, v any, records ...[]field) {
+	switch format {
+	case formatCSV:
+		jc.ResponseWriter.Header().Set("Content-Type", "text/csv")
+		w := csv.NewWriter(jc.ResponseWriter)
+		for i, record := range records {
+			if i == 0 {
+				header := make([]string, 0, len(record))
+				for _, f := range record {
+					header = append(header, f.Name)
+				}
+				w.Write(header)
+			}
+			row := make([]string, 0, len(record))
+			for _, f := range record {
+				row = append(row, formatField(f.Value))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+	case formatPrometheus:
+		if len(records) != 1 {
+			jc.Error(errors.New("prometheus format requires a single record"), http.StatusBadRequest)
+			return
+		}
+		var metrics []metric
+		for _, f := range records[0] {
+			value := formatField(f.Value)
+			switch v := f.Value.(type) {
+			case nil, types.BlockID:
+				// not a number
+				continue
+			case time.Time:
+				value = strconv.FormatInt(v.Unix(), 10)
+			}
+			metrics = append(metrics, metric{
+				Name:  "sia_" + f.Name,
+				Help:  f.Help,
+				Type:  metricTypeGauge,
+				Value: value,
+			})
+		}
+		jc.ResponseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(jc.ResponseWriter, metrics)
+	default:
+		jc.Encode(v)
+	}
+}
+
+// formatField formats a field value as text. Timestamps are formatted as
+// RFC 3339 and missing values are empty.
+func formatField(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return formatValue(v)
+	}
+}
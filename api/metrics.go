@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"go.sia.tech/core/types"
+)
+
+const (
+	metricTypeGauge   = "gauge"
+	metricTypeCounter = "counter"
+)
+
+// A metric is a single sample in the Prometheus text exposition format.
+type metric struct {
+	Name  string
+	Help  string
+	Type  string
+	Value string
+}
+
+// currencyGauge returns a gauge with the exact value of c in hastings.
+func currencyGauge(name, help string, c types.Currency) metric {
+	return metric{
+		Name:  name,
+		Help:  help,
+		Type:  metricTypeGauge,
+		Value: c.ExactString(),
+	}
+}
+
+// uint64Gauge returns a gauge with the value n.
+func uint64Gauge(name, help string, n uint64) metric {
+	return metric{
+		Name:  name,
+		Help:  help,
+		Type:  metricTypeGauge,
+		Value: strconv.FormatUint(n, 10),
+	}
+}
+
+// uint64Counter returns a counter with the value n.
+func uint64Counter(name, help string, n uint64) metric {
+	return metric{
+		Name:  name,
+		Help:  help,
+		Type:  metricTypeCounter,
+		Value: strconv.FormatUint(n, 10),
+	}
+}
+
+// writeMetrics writes metrics to w in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, metrics []metric) error {
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.Name, m.Help, m.Name, m.Type, m.Name, m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// jsonErrorWriter is an http.ResponseWriter that rewrites plain text error
+// responses as an ErrorResponse.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	isError bool
+	buf     bytes.Buffer
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	// jape and httprouter write errors with http.Error, which always sets a
+	// plain text content type
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.isError = true
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *jsonErrorWriter) Write(p []byte) (int, error) {
+	if w.isError {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with
+// http.ResponseController.
+func (w *jsonErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush writes the buffered error message, if any, as an ErrorResponse.
+func (w *jsonErrorWriter) flush() {
+	if !w.isError {
+		return
+	}
+	enc := json.NewEncoder(w.ResponseWriter)
+	enc.SetIndent("", "\t")
+	enc.Encode(ErrorResponse{Error: strings.TrimSpace(w.buf.String())})
+}
+
+// jsonErrors wraps h so that all error responses have a JSON body with a
+// stable shape.
+func jsonErrors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		jw := &jsonErrorWriter{ResponseWriter: w}
+		h.ServeHTTP(jw, req)
+		jw.flush()
+	})
+}
+
+// gzipMinSize is the minimum size of a response body before it is
+// compressed. Compressing smaller responses is not worth the overhead.
+const gzipMinSize = 1024
+
+// gzipWriter is an http.ResponseWriter that compresses the response body once
+// it exceeds gzipMinSize. Smaller responses are written uncompressed.
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	// uncompressed is set if the response was flushed before it was large
+	// enough to compress.
+	uncompressed bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	} else if w.uncompressed {
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() < gzipMinSize {
+		return len(p), nil
+	}
+
+	// the response is large enough to compress
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf.Reset()
+	return len(p), nil
+}
+
+// Flush flushes any buffered data to the client. If the response has not
+// been compressed yet, the buffered data is written uncompressed and the rest
+// of the response is not compressed, so streamed responses, e.g. server-sent
+// events, are not held back.
+func (w *gzipWriter) Flush() {
+	switch {
+	case w.gz != nil:
+		w.gz.Flush()
+	case !w.uncompressed:
+		w.uncompressed = true
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with
+// http.ResponseController.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the response, writing any buffered data uncompressed.
+func (w *gzipWriter) close() error {
+	switch {
+	case w.gz != nil:
+		return w.gz.Close()
+	case w.status == 0, w.uncompressed:
+		return nil // nothing was written, or everything was flushed
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding header allows a
+// gzip response.
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip is acceptable unless it has a quality of 0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressResponses wraps h to gzip large responses for clients that accept
+// it.
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// upgraded connections, e.g. websockets, are not HTTP responses
+		if req.Method == http.MethodHead || req.Header.Get("Upgrade") != "" || !acceptsGzip(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		h.ServeHTTP(gw, req)
+		gw.close()
+	})
+}
+
+// statusWriter is an http.ResponseWriter that records the status code of the
+// response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with
+// http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests wraps h to log each request. Successful requests are logged at
+// level, client errors at warn, and server errors at error.
+func logRequests(log *zap.Logger, level zapcore.Level, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, req)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		lvl := level
+		switch {
+		case sw.status >= 500:
+			lvl = zap.ErrorLevel
+		case sw.status >= 400:
+			lvl = max(level, zap.WarnLevel)
+		}
+		if ce := log.Check(lvl, "request"); ce != nil {
+			ce.Write(zap.String("method", req.Method), zap.String("path", req.URL.Path), zap.Int("status", sw.status), zap.Duration("duration", time.Since(start)), zap.String("remoteAddr", req.RemoteAddr))
+		}
+	})
+}
+
+// allowCORS wraps h to allow cross-origin requests from the given origins. An
+// origin of "*" allows all origins. If no origins are given, h is returned
+// unchanged and browsers will only allow same-origin requests.
+func allowCORS(origins []string, h http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return h
+	}
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[strings.TrimSpace(origin)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || (!allowed["*"] && !allowed[origin]) {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if allowed["*"] {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		// handle preflight requests
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", "3600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,1479 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.sia.tech/cmc-supply-api/build"
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/core/types"
+	"go.sia.tech/jape"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// tipCacheDuration is the duration the chain tip reported by walletd is
+	// cached for to avoid querying walletd on every request.
+	tipCacheDuration = 5 * time.Second
+
+	// defaultRichListLimit is the default number of addresses returned by
+	// [GET] /addresses/rich.
+	defaultRichListLimit = 100
+	// maxRichListLimit is the maximum number of addresses returned by
+	// [GET] /addresses/rich.
+	maxRichListLimit = 500
+
+	// csvBatchSize is the number of rows read from the store at a time when
+	// streaming CSV responses.
+	csvBatchSize = 1000
+	// csvBatchTimeout is the write deadline for each batch of rows streamed
+	// in a CSV response. The deadline is extended after each batch so large
+	// exports are not cut off by the server's write timeout.
+	csvBatchTimeout = 15 * time.Second
+
+	// unitsSC encodes currency values as a float64 number of siacoins.
+	unitsSC = "sc"
+	// unitsHastings encodes currency values as an exact string of hastings.
+	unitsHastings = "hastings"
+
+	// maxDecimals is the maximum number of decimal places that can be
+	// requested with the "decimals" query parameter. More places than a
+	// hasting would only add zeros.
+	maxDecimals = scDecimals
+
+	// blocksPerYear is the approximate number of blocks mined in a year at
+	// the target block time of 10 minutes.
+	blocksPerYear = 144 * 365
+
+	// maxBurnedHistoryPoints is the maximum number of points returned by
+	// [GET] /supply/burned/history.
+	maxBurnedHistoryPoints = 1000
+
+	// mediaTypeJSON is the default media type of single value responses.
+	mediaTypeJSON = "application/json"
+	// mediaTypeText requests a single value as plain text.
+	mediaTypeText = "text/plain"
+	// mediaTypeValue requests a single value wrapped in a ValueResponse, the
+	// same as setting meta=true.
+	mediaTypeValue = "application/vnd.sia.value+json"
+)
+
+type (
+	// A Store provides the indexed supply state.
+	Store interface {
+		State() (index.State, error)
+		FoundationTreasury() (types.Currency, error)
+		// Supply returns the current state and the value of the foundation
+		// treasury at the same indexed height.
+		Supply() (index.State, types.Currency, error)
+		// SupplyExcluding is like Supply, but the returned value also
+		// includes the balances of addrs. Foundation addresses are only
+		// counted once.
+		SupplyExcluding(addrs []types.Address) (index.State, types.Currency, error)
+		// LockedSupply returns the current state and the value of the
+		// immature outputs at the same indexed height.
+		LockedSupply() (index.State, types.Currency, error)
+		// RichList returns the addresses with the largest balances.
+		RichList(limit, offset int) ([]index.AddressBalance, error)
+		// RichListAfter returns the addresses that follow the given balance
+		// and address in the rich list.
+		RichListAfter(balance types.Currency, address types.Address, limit int) ([]index.AddressBalance, error)
+		// FoundationAddresses returns the balances of the foundation
+		// addresses, sorted by balance in descending order.
+		FoundationAddresses() ([]index.AddressBalance, error)
+		// AddressBalance returns the balance of an address and whether it
+		// is a foundation address.
+		AddressBalance(addr types.Address) (types.Currency, bool, error)
+		// SupplyAtHeight returns the state after the block at the given
+		// height was applied.
+		SupplyAtHeight(height uint64) (index.State, error)
+		// SupplyAtTime returns the state after the block with the latest
+		// timestamp at or before t was applied.
+		SupplyAtTime(t time.Time) (index.State, error)
+		// SupplyHistory returns up to limit states with heights in the
+		// range [from, to], sorted by height.
+		SupplyHistory(from, to uint64, limit int) ([]index.State, error)
+		// SampledSupplyHistory returns up to limit states at every step
+		// blocks in the range [from, to], sorted by height.
+		SampledSupplyHistory(from, to, step uint64, limit int) ([]index.State, error)
+	}
+
+	// A Chain provides the current chain tip.
+	Chain interface {
+		ConsensusTip() (types.ChainIndex, error)
+	}
+
+	// A Reindexer rewinds the index so blocks are reapplied.
+	Reindexer interface {
+		Reindex(ctx context.Context, from uint64) error
+	}
+
+	// A Maintainer performs database maintenance.
+	Maintainer interface {
+		// Vacuum reclaims unused space and returns the resulting size of
+		// the database in bytes.
+		Vacuum() (int64, error)
+		// Backup writes a copy of the database to path and returns its
+		// size in bytes.
+		Backup(path string) (int64, error)
+	}
+
+	// An Auditor checks the index for consistency.
+	Auditor interface {
+		Audit() (index.Audit, error)
+	}
+
+	// A StallMonitor reports whether the index has stopped advancing.
+	StallMonitor interface {
+		Stalled() (bool, time.Duration)
+	}
+
+	// A ReorgCounter reports the number of blocks applied and reverted by
+	// the indexer and the deepest reorg observed.
+	ReorgCounter interface {
+		Counts() (applied, reverted, maxDepth uint64)
+	}
+
+	// A Txpool provides the unconfirmed transactions of a walletd node.
+	Txpool interface {
+		TxpoolTransactions() ([]types.Transaction, []types.V2Transaction, error)
+	}
+
+	// A ServerOption configures a server.
+	ServerOption func(*server)
+
+	server struct {
+		store Store
+		chain Chain
+
+		maxHealthLag uint64
+		// maxRangeSpan and maxExportSpan are the maximum number of blocks
+		// a range query can span for buffered and streamed responses. Zero
+		// allows any span.
+		maxRangeSpan  uint64
+		maxExportSpan uint64
+		// maxSupply is the configured maximum supply in siacoins. Sia has no
+		// hard cap, so it is nil unless set by the deployment.
+		maxSupply *float64
+
+		corsOrigins []string
+
+		// walletdAddress is the address of the walletd API the index is
+		// synced with. It is only used for reporting.
+		walletdAddress string
+
+		adminKey        string
+		protectedRoutes map[string]bool
+
+		reindexer  Reindexer
+		maintainer Maintainer
+		auditor    Auditor
+		txpool     Txpool
+		exclusions *ExclusionList
+		stalls     StallMonitor
+		reorgs     ReorgCounter
+
+		// exactDecimals encodes siacoin values as exact decimal strings
+		// instead of float64 numbers unless overridden by the "exact" query
+		// parameter
+		exactDecimals bool
+
+		notifier *Notifier
+		hub      *supplyHub
+
+		log             *zap.Logger
+		requestLogLevel zapcore.Level
+
+		mu          sync.Mutex
+		tip         types.ChainIndex
+		tipLastSeen time.Time
+	}
+)
+
+// WithMaxHealthLag sets the maximum number of blocks the index can be behind
+// the chain tip before the server reports itself as unhealthy.
+func WithMaxHealthLag(n uint64) ServerOption {
+	return func(s *server) {
+		s.maxHealthLag = n
+	}
+}
+
+// WithMaxRangeSpan sets the maximum number of blocks the range of a buffered
+// history query, such as [GET] /supply/burned/history, can span. By default,
+// any span is allowed.
+func WithMaxRangeSpan(n uint64) ServerOption {
+	return func(s *server) {
+		s.maxRangeSpan = n
+	}
+}
+
+// WithMaxExportSpan sets the maximum number of blocks the range of a streamed
+// export, such as [GET] /supply/history.csv, can span. Exports are written in
+// batches, so the limit can be larger than the range span. By default, any
+// span is allowed.
+func WithMaxExportSpan(n uint64) ServerOption {
+	return func(s *server) {
+		s.maxExportSpan = n
+	}
+}
+
+// WithMaxSupply sets the maximum supply, in siacoins, reported by the server.
+// Sia's supply is uncapped, so by default the maximum supply is reported as
+// null.
+func WithMaxSupply(sc float64) ServerOption {
+	return func(s *server) {
+		s.maxSupply = &sc
+	}
+}
+
+// WithLogger sets the logger used to log requests.
+func WithLogger(log *zap.Logger) ServerOption {
+	return func(s *server) {
+		s.log = log
+	}
+}
+
+// WithRequestLogLevel sets the level successful requests are logged at.
+// Failed requests are always logged at the warn level or higher.
+func WithRequestLogLevel(level zapcore.Level) ServerOption {
+	return func(s *server) {
+		s.requestLogLevel = level
+	}
+}
+
+// WithCORSOrigins allows cross-origin requests from the given origins. An
+// origin of "*" allows requests from any origin. By default, cross-origin
+// requests are not allowed.
+func WithCORSOrigins(origins []string) ServerOption {
+	return func(s *server) {
+		s.corsOrigins = origins
+	}
+}
+
+// WithWalletdAddress sets the walletd API address reported by [GET] /version.
+func WithWalletdAddress(addr string) ServerOption {
+	return func(s *server) {
+		s.walletdAddress = addr
+	}
+}
+
+// WithAdminKey sets the key required to access protected routes. The key is
+// accepted in the X-API-Key header or as a bearer token.
+func WithAdminKey(key string) ServerOption {
+	return func(s *server) {
+		s.adminKey = key
+	}
+}
+
+// WithProtectedRoutes requires the admin key to access the routes with the
+// given paths, e.g. "/metrics". If no admin key is set, protected routes
+// reject all requests.
+func WithProtectedRoutes(paths ...string) ServerOption {
+	return func(s *server) {
+		for _, path := range paths {
+			s.protectedRoutes[strings.TrimSpace(path)] = true
+		}
+	}
+}
+
+// WithReindexer enables [POST] /admin/reindex. The route always requires the
+// admin key.
+func WithReindexer(r Reindexer) ServerOption {
+	return func(s *server) {
+		s.reindexer = r
+	}
+}
+
+// WithMaintainer enables [POST] /admin/vacuum and [POST] /admin/backup. The
+// routes always require the admin key.
+func WithMaintainer(m Maintainer) ServerOption {
+	return func(s *server) {
+		s.maintainer = m
+	}
+}
+
+// WithAuditor enables [GET] /admin/audit. The route always requires the
+// admin key.
+func WithAuditor(a Auditor) ServerOption {
+	return func(s *server) {
+		s.auditor = a
+	}
+}
+
+// WithExactDecimals encodes siacoin values as exact decimal strings instead
+// of float64 numbers by default. Clients can override it with the "exact"
+// query parameter. Float64 numbers are the default because CoinMarketCap and
+// CoinGecko expect them.
+func WithExactDecimals(exact bool) ServerOption {
+	return func(s *server) {
+		s.exactDecimals = exact
+	}
+}
+
+// WithExclusionList subtracts the balances of the addresses in l from every
+// reported circulating supply and enables [POST] /admin/exclusions/reload.
+// The route always requires the admin key.
+func WithExclusionList(l *ExclusionList) ServerOption {
+	return func(s *server) {
+		s.exclusions = l
+	}
+}
+
+// WithStallMonitor reports [GET] /health as unhealthy while m reports that
+// the index has stalled, even if it is synced with walletd.
+func WithStallMonitor(m StallMonitor) ServerOption {
+	return func(s *server) {
+		s.stalls = m
+	}
+}
+
+// WithReorgCounter adds the indexer's applied and reverted block counts and
+// the maximum reorg depth to [GET] /metrics.
+func WithReorgCounter(c ReorgCounter) ServerOption {
+	return func(s *server) {
+		s.reorgs = c
+	}
+}
+
+// WithNotifier sets the Notifier that signals new states to the supply
+// streams. Without one, the store is polled for changes.
+func WithNotifier(n *Notifier) ServerOption {
+	return func(s *server) {
+		s.notifier = n
+	}
+}
+
+// WithTxpool enables [GET] /txpool/supply.
+func WithTxpool(tp Txpool) ServerOption {
+	return func(s *server) {
+		s.txpool = tp
+	}
+}
+
+// requireAdminKey wraps h to reject requests that do not include the admin
+// key.
+func (s *server) requireAdminKey(h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		key := jc.Request.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(jc.Request.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		if s.adminKey == "" || key == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.adminKey)) != 1 {
+			jc.ResponseWriter.Header().Set("WWW-Authenticate", "Bearer")
+			jc.Error(errors.New("missing or invalid API key"), http.StatusUnauthorized)
+			return
+		}
+		h(jc)
+	}
+}
+
+// encodeStatus writes the JSON encoding of v to the response body with the
+// provided status code.
+func encodeStatus(jc jape.Context, status int, v any) {
+	jc.ResponseWriter.Header().Set("Content-Type", "application/json")
+	jc.ResponseWriter.WriteHeader(status)
+	jc.Encode(v)
+}
+
+// chainTip returns the current chain tip. The tip is cached for a short
+// duration.
+func (s *server) chainTip() (types.ChainIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.tipLastSeen) < tipCacheDuration {
+		return s.tip, nil
+	}
+
+	tip, err := s.chain.ConsensusTip()
+	if err != nil {
+		return types.ChainIndex{}, err
+	}
+	s.tip = tip
+	s.tipLastSeen = time.Now()
+	return tip, nil
+}
+
+// blocksBehind returns the number of blocks the indexed state is behind the
+// chain tip.
+func blocksBehind(state index.State, tip types.ChainIndex) uint64 {
+	if tip.Height > state.Index.Height {
+		return tip.Height - state.Index.Height
+	}
+	return 0
+}
+
+// stateETag returns the ETag of a response derived from state. It is the
+// indexed block ID, so it changes exactly when a new block is indexed.
+func stateETag(state index.State) string {
+	return `"` + state.Index.ID.String() + `"`
+}
+
+// exclusionsETag returns the ETag of a response derived from state and the
+// circulating supply exclusions. The response changes when a new block is
+// indexed or when the exclusion list is reloaded.
+func exclusionsETag(state index.State, excluded []types.Address) string {
+	buf := make([]byte, 0, len(excluded)*len(types.Address{}))
+	for _, addr := range excluded {
+		buf = append(buf, addr[:]...)
+	}
+	h := types.HashBytes(buf)
+	return `"` + state.Index.ID.String() + "-" + hex.EncodeToString(h[:8]) + `"`
+}
+
+// circulatingSupply returns the current state and the circulating supply,
+// which excludes the foundation treasury and the balances of the addresses in
+// exclusions. Every response that reports the circulating supply uses it so
+// that they agree. The returned ETag changes when a new block is indexed or
+// when the exclusion list is reloaded.
+func circulatingSupply(store Store, exclusions *ExclusionList) (state index.State, circulating types.Currency, etag string, err error) {
+	var excluded []types.Address
+	if exclusions != nil {
+		excluded = exclusions.Addresses()
+	}
+	state, nonCirculating, err := store.SupplyExcluding(excluded)
+	if err != nil {
+		return index.State{}, types.ZeroCurrency, "", err
+	}
+	return state, state.CirculatingSupply.Sub(nonCirculating), exclusionsETag(state, excluded), nil
+}
+
+// checkNotModified sets the ETag header of a response. Last-Modified is not
+// set because block timestamps are not monotonic, so a new block can have an
+// earlier timestamp than its parent. If the client's cached copy is still
+// current, a 304 Not Modified response is written and true is returned.
+func checkNotModified(jc jape.Context, etag string) bool {
+	jc.ResponseWriter.Header().Set("ETag", etag)
+	for _, tag := range strings.Split(jc.Request.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			jc.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedMediaType returns the single value media type preferred by the
+// request's Accept header. Media ranges are tried in order of their quality
+// values, and the first supported one is returned. JSON is returned if the
+// header is missing or does not list a supported media type.
+func acceptedMediaType(r *http.Request) string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		switch mr.mediaType {
+		case mediaTypeText, mediaTypeValue:
+			return mr.mediaType
+		case mediaTypeJSON, "application/*", "*/*":
+			return mediaTypeJSON
+		}
+	}
+	return mediaTypeJSON
+}
+
+// formatValue formats a value returned by encodeCurrency as plain text.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case types.Currency:
+		return v.ExactString()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// encodeCurrency writes c to the response body in the units requested by the
+// "units" query parameter. Siacoins are used if no units are specified. If
+// the "meta" query parameter is set, the value is wrapped in a ValueResponse
+// with the index it was read at. The format can also be negotiated with the
+// Accept header: text/plain returns the bare value as text, and
+// application/vnd.sia.value+json is equivalent to meta=true. If the "decimals"
+// query parameter is set, siacoins are encoded as a string with exactly that
+// many decimal places. Otherwise, they are encoded as the exact decimal string
+// if requested with the "exact" query parameter or by the server default.
+// state is the indexed state c was read at.
+func (s *server) encodeCurrency(jc jape.Context, state index.State, c types.Currency) {
+	s.encodeCurrencyETag(jc, state, stateETag(state), c)
+}
+
+// encodeCurrencyETag is like encodeCurrency, but uses etag for conditional
+// requests instead of the indexed block ID.
+func (s *server) encodeCurrencyETag(jc jape.Context, state index.State, etag string, c types.Currency) {
+	units := unitsSC
+	decimals := -1
+	var meta bool
+	exact := s.exactDecimals
+	if jc.DecodeForm("units", &units) != nil || jc.DecodeForm("meta", &meta) != nil || jc.DecodeForm("decimals", &decimals) != nil || jc.DecodeForm("exact", &exact) != nil {
+		return
+	} else if jc.Request.FormValue("decimals") != "" && (decimals < 0 || decimals > maxDecimals) {
+		jc.Error(fmt.Errorf("decimals must be between 0 and %d", maxDecimals), http.StatusBadRequest)
+		return
+	}
+
+	var value any
+	switch units {
+	case unitsSC:
+		if decimals >= 0 {
+			value = hastingsToSCString(c, int32(decimals))
+		} else {
+			value = scValue(c, exact)
+		}
+	case unitsHastings:
+		if decimals >= 0 {
+			jc.Error(errors.New("decimals can only be used with sc units"), http.StatusBadRequest)
+			return
+		}
+		value = c
+	default:
+		jc.Error(fmt.Errorf("unknown units %q", units), http.StatusBadRequest)
+		return
+	}
+
+	mediaType := acceptedMediaType(jc.Request)
+	jc.ResponseWriter.Header().Add("Vary", "Accept")
+	if checkNotModified(jc, etag) {
+		return
+	} else if meta || mediaType == mediaTypeValue {
+		resp := ValueResponse{
+			Value:   value,
+			Height:  state.Index.Height,
+			BlockID: state.Index.ID,
+		}
+		if !meta {
+			// jape always responds with application/json
+			jc.ResponseWriter.Header().Set("Content-Type", mediaTypeValue)
+			enc := json.NewEncoder(jc.ResponseWriter)
+			enc.SetIndent("", "\t")
+			enc.Encode(resp)
+			return
+		}
+		jc.Encode(resp)
+		return
+	} else if mediaType == mediaTypeText {
+		jc.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		jc.ResponseWriter.Write([]byte(formatValue(value)))
+		return
+	}
+	jc.Encode(value)
+}
+
+func (s *server) handleGETTip(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	tip, err := s.chainTip()
+	if err != nil {
+		jc.Error(fmt.Errorf("failed to get chain tip: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	behind := blocksBehind(state, tip)
+	jc.Encode(TipResponse{
+		Index:        state.Index,
+		ChainTip:     tip,
+		Synced:       behind <= s.maxHealthLag,
+		BlocksBehind: behind,
+	})
+}
+
+func (s *server) handleGETSupply(jc jape.Context) {
+	exact := s.exactDecimals
+	if jc.DecodeForm("exact", &exact) != nil {
+		return
+	}
+	format, ok := decodeFormat(jc)
+	if !ok {
+		return
+	}
+	state, circulating, etag, err := circulatingSupply(s.store, s.exclusions)
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	} else if checkNotModified(jc, etag) {
+		return
+	}
+	resp := SupplyResponse{
+		Index:             state.Index,
+		TotalSupply:       scValue(state.TotalSupply, exact),
+		CirculatingSupply: scValue(circulating, exact),
+		SiafundPool:       scValue(state.SiafundPool, exact),
+		MaxSupply:         s.maxSupply,
+		LastUpdated:       state.Timestamp,
+	}
+	var maxSupply any
+	if s.maxSupply != nil {
+		maxSupply = *s.maxSupply
+	}
+	writeFormatted(jc, format, resp, []field{
+		{"height", "The height of the last indexed block.", state.Index.Height},
+		{"block_id", "", state.Index.ID},
+		{"total_supply", "The total supply of Siacoin in siacoins.", resp.TotalSupply},
+		{"circulating_supply", "The circulating supply of Siacoin in siacoins, excluding the foundation treasury and excluded addresses.", resp.CirculatingSupply},
+		{"siafund_pool", "The value of the siafund pool in siacoins.", resp.SiafundPool},
+		{"max_supply", "The configured maximum supply of Siacoin in siacoins.", maxSupply},
+		{"last_updated", "The timestamp of the last indexed block.", resp.LastUpdated},
+	})
+}
+
+func (s *server) handleGETCoinGeckoSupply(jc jape.Context) {
+	exact := s.exactDecimals
+	if jc.DecodeForm("exact", &exact) != nil {
+		return
+	}
+	state, circulating, etag, err := circulatingSupply(s.store, s.exclusions)
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	} else if checkNotModified(jc, etag) {
+		return
+	}
+	jc.Encode(CoinGeckoSupplyResponse{
+		CirculatingSupply: scValue(circulating, exact),
+		TotalSupply:       scValue(state.TotalSupply, exact),
+	})
+}
+
+// handleGETCMCSupply returns the circulating and total supply in the exact
+// shape CoinMarketCap ingests. GET /supply has more fields for other clients.
+func (s *server) handleGETCMCSupply(jc jape.Context) {
+	state, circulating, etag, err := circulatingSupply(s.store, s.exclusions)
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	} else if checkNotModified(jc, etag) {
+		return
+	}
+	jc.Encode(CMCSupplyResponse{
+		CirculatingSupply: hastingsToSC(circulating),
+		TotalSupply:       hastingsToSC(state.TotalSupply),
+	})
+}
+
+func (s *server) handleGETSupplyTotal(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.TotalSupply)
+}
+
+func (s *server) handleGETSupplyCirculating(jc jape.Context) {
+	state, circulating, etag, err := circulatingSupply(s.store, s.exclusions)
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	}
+	s.encodeCurrencyETag(jc, state, etag, circulating)
+}
+
+func (s *server) handleGETSupplyBurned(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.BurnedSupply)
+}
+
+// handleGETSupplyBurnedVoid returns the cumulative value sent to the void
+// address, excluding contract burns.
+func (s *server) handleGETSupplyBurnedVoid(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.VoidBurnedSupply)
+}
+
+// handleGETSupplyBurnedContracts returns the cumulative value burned by
+// expired file contracts.
+func (s *server) handleGETSupplyBurnedContracts(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.ContractBurnedSupply)
+}
+
+// handleGETSupplyLocked returns the value of the immature outputs, such as
+// miner payouts and the foundation subsidy, that cannot be spent until they
+// mature.
+func (s *server) handleGETSupplyLocked(jc jape.Context) {
+	state, locked, err := s.store.LockedSupply()
+	if jc.Check("failed to get locked supply", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, locked)
+}
+
+// handleGETSupplySpendable returns the value of the mature outputs. Together
+// with the locked supply, it adds up to the circulating supply including the
+// foundation treasury.
+func (s *server) handleGETSupplySpendable(jc jape.Context) {
+	state, locked, err := s.store.LockedSupply()
+	if jc.Check("failed to get locked supply", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.CirculatingSupply.Sub(locked))
+}
+
+// handleGETSupplyImmature returns the value of the block rewards and
+// foundation subsidies that have not matured.
+func (s *server) handleGETSupplyImmature(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.ImmatureSupply)
+}
+
+// handleGETSupplyBlockRewards returns the cumulative value of the block
+// rewards minted up to the indexed height, excluding fees and the foundation
+// subsidy.
+func (s *server) handleGETSupplyBlockRewards(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.BlockRewardSupply)
+}
+
+// handleGETSupplyMax returns the configured maximum supply in siacoins or
+// null if the supply is uncapped.
+func (s *server) handleGETSupplyMax(jc jape.Context) {
+	jc.Encode(s.maxSupply)
+}
+
+func (s *server) handleGETFoundationTreasury(jc jape.Context) {
+	state, foundationTreasury, err := s.store.Supply()
+	if jc.Check("failed to get foundation treasury", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, foundationTreasury)
+}
+
+// handleGETFoundationAddresses returns the foundation addresses and their
+// balances, which sum to the foundation treasury.
+func (s *server) handleGETFoundationAddresses(jc jape.Context) {
+	balances, err := s.store.FoundationAddresses()
+	if jc.Check("failed to get foundation addresses", err) != nil {
+		return
+	}
+	jc.Encode(balances)
+}
+
+// handleGETFoundationSubsidy returns the cumulative value of the foundation
+// subsidies minted up to the indexed height.
+func (s *server) handleGETFoundationSubsidy(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.FoundationSubsidy)
+}
+
+func (s *server) handlePOSTAdminReindex(jc jape.Context) {
+	var from uint64
+	if jc.Request.FormValue("from") == "" {
+		jc.Error(errors.New("from is required"), http.StatusBadRequest)
+		return
+	} else if jc.DecodeForm("from", &from) != nil {
+		return
+	}
+
+	err := s.reindexer.Reindex(jc.Request.Context(), from)
+	if errors.Is(err, index.ErrNotBestChain) {
+		jc.Error(err, http.StatusConflict)
+		return
+	} else if jc.Check("failed to reindex", err) != nil {
+		return
+	}
+	jc.ResponseWriter.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handlePOSTAdminVacuum(jc jape.Context) {
+	start := time.Now()
+	size, err := s.maintainer.Vacuum()
+	if jc.Check("failed to vacuum database", err) != nil {
+		return
+	}
+	jc.Encode(MaintenanceResponse{
+		Size:     size,
+		Duration: time.Since(start),
+	})
+}
+
+func (s *server) handlePOSTAdminBackup(jc jape.Context) {
+	var path string
+	if jc.DecodeForm("path", &path) != nil {
+		return
+	} else if path == "" {
+		jc.Error(errors.New("path is required"), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	size, err := s.maintainer.Backup(path)
+	if jc.Check("failed to back up database", err) != nil {
+		return
+	}
+	jc.Encode(MaintenanceResponse{
+		Path:     path,
+		Size:     size,
+		Duration: time.Since(start),
+	})
+}
+
+func (s *server) handleGETAdminAudit(jc jape.Context) {
+	audit, err := s.auditor.Audit()
+	if jc.Check("failed to audit index", err) != nil {
+		return
+	}
+	diff := new(big.Int).Sub(audit.AddressBalances.Big(), audit.CirculatingSupply.Big())
+	jc.Encode(AuditResponse{
+		Index:             audit.Index,
+		CirculatingSupply: audit.CirculatingSupply,
+		AddressBalances:   audit.AddressBalances,
+		Difference:        diff.String(),
+		Consistent:        audit.Consistent(),
+	})
+}
+
+// handlePOSTAdminExclusionsReload reloads the addresses excluded from the
+// circulating supply.
+func (s *server) handlePOSTAdminExclusionsReload(jc jape.Context) {
+	addrs, err := s.exclusions.Reload()
+	if jc.Check("failed to reload exclusion list", err) != nil {
+		return
+	}
+	s.log.Info("reloaded circulating supply exclusions", zap.Int("addresses", len(addrs)))
+	jc.Encode(ExclusionListResponse{Addresses: addrs})
+}
+
+// handleGETSupplyInflation returns the total supply growth over the last
+// year of indexed history. If the history does not extend back a full year,
+// the rate is calculated from the oldest available history and the response
+// is marked as partial.
+func (s *server) handleGETSupplyInflation(jc jape.Context) {
+	end, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+
+	var from uint64
+	if end.Index.Height > blocksPerYear {
+		from = end.Index.Height - blocksPerYear
+	}
+	history, err := s.store.SupplyHistory(from, end.Index.Height, 1)
+	if jc.Check("failed to get supply history", err) != nil {
+		return
+	} else if len(history) == 0 {
+		jc.Error(errors.New("no supply history"), http.StatusNotFound)
+		return
+	}
+	start := history[0]
+
+	var rate float64
+	if !start.TotalSupply.IsZero() {
+		startTotal := decimal.NewFromBigInt(start.TotalSupply.Big(), 0)
+		endTotal := decimal.NewFromBigInt(end.TotalSupply.Big(), 0)
+		rate = endTotal.Sub(startTotal).Div(startTotal).InexactFloat64()
+	}
+
+	if checkNotModified(jc, stateETag(end)) {
+		return
+	}
+	jc.Encode(InflationResponse{
+		Rate:           rate,
+		StartHeight:    start.Index.Height,
+		StartTimestamp: start.Timestamp,
+		EndHeight:      end.Index.Height,
+		EndTimestamp:   end.Timestamp,
+		Partial:        end.Index.Height-start.Index.Height < blocksPerYear,
+	})
+}
+
+// handleGETSupplyEmission returns the change in the total supply over the
+// last window blocks of indexed history. If the history does not extend back
+// the full window, the change is calculated from the oldest available history
+// and the response is marked as partial.
+func (s *server) handleGETSupplyEmission(jc jape.Context) {
+	var window uint64
+	exact := s.exactDecimals
+	if jc.Request.FormValue("window") == "" {
+		jc.Error(errors.New("window is required"), http.StatusBadRequest)
+		return
+	} else if jc.DecodeForm("window", &window) != nil || jc.DecodeForm("exact", &exact) != nil {
+		return
+	} else if window == 0 {
+		jc.Error(errors.New("window must be positive"), http.StatusBadRequest)
+		return
+	}
+
+	end, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+
+	var from uint64
+	if end.Index.Height > window {
+		from = end.Index.Height - window
+	}
+	history, err := s.store.SupplyHistory(from, end.Index.Height, 1)
+	if jc.Check("failed to get supply history", err) != nil {
+		return
+	} else if len(history) == 0 {
+		jc.Error(errors.New("no supply history"), http.StatusNotFound)
+		return
+	}
+	start := history[0]
+
+	// burns reduce the total supply, so the emission can be negative
+	emission, negative := end.TotalSupply.SubWithUnderflow(start.TotalSupply)
+	if negative {
+		emission = start.TotalSupply.Sub(end.TotalSupply)
+	}
+	emissionHastings := emission.ExactString()
+	if negative {
+		emissionHastings = "-" + emissionHastings
+	}
+
+	if checkNotModified(jc, stateETag(end)) {
+		return
+	}
+	jc.Encode(EmissionResponse{
+		Window:      window,
+		Emission:    emissionHastings,
+		EmissionSC:  signedSCValue(emission, negative, exact),
+		StartHeight: start.Index.Height,
+		EndHeight:   end.Index.Height,
+		Partial:     end.Index.Height-start.Index.Height < window,
+	})
+}
+
+// handleGETSupplyBurnedHistory returns the burned supply at every step
+// blocks in the range [from, to] for charting. If the range would return more
+// than maxBurnedHistoryPoints points, the step is increased to fit.
+func (s *server) handleGETSupplyBurnedHistory(jc jape.Context) {
+	from, to, step := uint64(0), uint64(math.MaxUint64), uint64(1)
+	if jc.DecodeForm("from", &from) != nil || jc.DecodeForm("to", &to) != nil || jc.DecodeForm("step", &step) != nil {
+		return
+	} else if from > to {
+		jc.Error(errors.New("from must not be greater than to"), http.StatusBadRequest)
+		return
+	} else if step == 0 {
+		jc.Error(errors.New("step must be positive"), http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	to = min(to, state.Index.Height)
+	if from <= to && !checkSpan(jc, from, to, s.maxRangeSpan) {
+		return
+	}
+
+	resp := BurnedHistoryResponse{
+		Step:   step,
+		Points: []BurnedSupplyPoint{},
+	}
+	if from <= to {
+		if span := to - from; span/step >= maxBurnedHistoryPoints {
+			resp.Step = span/maxBurnedHistoryPoints + 1
+		}
+		history, err := s.store.SampledSupplyHistory(from, to, resp.Step, maxBurnedHistoryPoints)
+		if jc.Check("failed to get supply history", err) != nil {
+			return
+		}
+		for _, h := range history {
+			resp.Points = append(resp.Points, BurnedSupplyPoint{
+				Height:       h.Index.Height,
+				BurnedSupply: h.BurnedSupply,
+			})
+		}
+	}
+
+	if checkNotModified(jc, stateETag(state)) {
+		return
+	}
+	jc.Encode(resp)
+}
+
+// handleGETSupplyHistory returns the supply after the block at the "height"
+// query parameter, or after the latest block mined at or before the "time"
+// query parameter, was applied.
+func (s *server) handleGETSupplyHistory(jc jape.Context) {
+	var height uint64
+	var at time.Time
+	byHeight, byTime := jc.Request.FormValue("height") != "", jc.Request.FormValue("time") != ""
+	if !byHeight && !byTime {
+		jc.Error(errors.New("height or time is required"), http.StatusBadRequest)
+		return
+	} else if byHeight && byTime {
+		jc.Error(errors.New("height and time cannot both be set"), http.StatusBadRequest)
+		return
+	} else if jc.DecodeForm("height", &height) != nil || jc.DecodeForm("time", &at) != nil {
+		return
+	}
+
+	format, ok := decodeFormat(jc)
+	if !ok {
+		return
+	}
+
+	var state index.State
+	var err error
+	if byTime {
+		state, err = s.store.SupplyAtTime(at)
+	} else {
+		state, err = s.store.SupplyAtHeight(height)
+	}
+	if errors.Is(err, index.ErrNotFound) && byTime {
+		jc.Error(fmt.Errorf("no supply history at or before %v", at.Format(time.RFC3339)), http.StatusNotFound)
+		return
+	} else if errors.Is(err, index.ErrNotFound) {
+		jc.Error(fmt.Errorf("no supply history at height %d", height), http.StatusNotFound)
+		return
+	} else if jc.Check("failed to get supply history", err) != nil {
+		return
+	}
+	writeFormatted(jc, format, SupplyHistoryResponse{
+		Index:             state.Index,
+		Timestamp:         state.Timestamp,
+		TotalSupply:       state.TotalSupply,
+		CirculatingSupply: state.CirculatingSupply,
+		BurnedSupply:      state.BurnedSupply,
+	}, []field{
+		{"height", "The height of the block.", state.Index.Height},
+		{"block_id", "", state.Index.ID},
+		{"timestamp", "The timestamp of the block.", state.Timestamp},
+		{"total_supply_hastings", "The total supply of Siacoin in hastings.", state.TotalSupply},
+		{"circulating_supply_hastings", "The circulating supply of Siacoin in hastings, including the foundation treasury.", state.CirculatingSupply},
+		{"burned_supply_hastings", "The supply of Siacoin that has been verifiably burned in hastings.", state.BurnedSupply},
+	})
+}
+
+func (s *server) handleGETSupplyHistoryCSV(jc jape.Context) {
+	from, to := uint64(0), uint64(math.MaxUint64)
+	units := unitsSC
+	exact := s.exactDecimals
+	if jc.DecodeForm("from", &from) != nil || jc.DecodeForm("to", &to) != nil || jc.DecodeForm("units", &units) != nil || jc.DecodeForm("exact", &exact) != nil {
+		return
+	} else if from > to {
+		jc.Error(errors.New("from must not be greater than to"), http.StatusBadRequest)
+		return
+	}
+
+	var formatCurrency func(types.Currency) string
+	switch units {
+	case unitsSC:
+		formatCurrency = func(c types.Currency) string { return formatValue(scValue(c, exact)) }
+	case unitsHastings:
+		formatCurrency = types.Currency.ExactString
+	default:
+		jc.Error(fmt.Errorf("unknown units %q", units), http.StatusBadRequest)
+		return
+	}
+
+	if s.maxExportSpan > 0 {
+		// the default range extends to the indexed height
+		state, err := s.store.State()
+		if jc.Check("failed to get state", err) != nil {
+			return
+		}
+		to = min(to, state.Index.Height)
+		if from <= to && !checkSpan(jc, from, to, s.maxExportSpan) {
+			return
+		}
+	}
+
+	// read the first batch before writing the header so errors can still be
+	// reported to the client
+	history, err := s.store.SupplyHistory(from, to, csvBatchSize)
+	if jc.Check("failed to get supply history", err) != nil {
+		return
+	}
+
+	jc.ResponseWriter.Header().Set("Content-Type", "text/csv")
+	jc.ResponseWriter.Header().Set("Content-Disposition", `attachment; filename="supply_history.csv"`)
+	rc := http.NewResponseController(jc.ResponseWriter)
+	w := csv.NewWriter(jc.ResponseWriter)
+	w.Write([]string{"height", "block_id", "total_supply", "circulating_supply", "burned_supply"})
+	for len(history) > 0 {
+		rc.SetWriteDeadline(time.Now().Add(csvBatchTimeout))
+		for _, state := range history {
+			w.Write([]string{
+				strconv.FormatUint(state.Index.Height, 10),
+				state.Index.ID.String(),
+				formatCurrency(state.TotalSupply),
+				formatCurrency(state.CirculatingSupply),
+				formatCurrency(state.BurnedSupply),
+			})
+		}
+		if w.Flush(); w.Error() != nil {
+			return
+		}
+
+		last := history[len(history)-1].Index.Height
+		if last >= to {
+			break
+		}
+		history, err = s.store.SupplyHistory(last+1, to, csvBatchSize)
+		if err != nil {
+			// the status has already been sent, abort the response so the
+			// client does not mistake a partial export for a complete one
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+func (s *server) handleGETSiafundPool(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.SiafundPool)
+}
+
+// handleGETSiafundClaims returns the cumulative value of the siafund claims
+// paid out up to the indexed height.
+func (s *server) handleGETSiafundClaims(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	s.encodeCurrency(jc, state, state.SiafundClaims)
+}
+
+func (s *server) handleGETAddressesRich(jc jape.Context) {
+	limit, offset := defaultRichListLimit, 0
+	var cursor RichListCursor
+	if jc.DecodeForm("limit", &limit) != nil || jc.DecodeForm("offset", &offset) != nil || jc.DecodeForm("cursor", &cursor) != nil {
+		return
+	} else if limit <= 0 || limit > maxRichListLimit {
+		jc.Error(fmt.Errorf("limit must be between 1 and %d", maxRichListLimit), http.StatusBadRequest)
+		return
+	} else if offset < 0 {
+		jc.Error(errors.New("offset must be non-negative"), http.StatusBadRequest)
+		return
+	}
+
+	var balances []index.AddressBalance
+	var err error
+	if jc.Request.FormValue("cursor") == "" {
+		balances, err = s.store.RichList(limit, offset)
+	} else if offset != 0 {
+		jc.Error(errors.New("offset cannot be used with cursor"), http.StatusBadRequest)
+		return
+	} else {
+		balances, err = s.store.RichListAfter(cursor.Balance, cursor.Address, limit)
+	}
+	if jc.Check("failed to get rich list", err) != nil {
+		return
+	}
+
+	resp := RichListResponse{Addresses: balances}
+	// a full page may be followed by more addresses
+	if len(balances) == limit {
+		last := balances[len(balances)-1]
+		resp.NextCursor = RichListCursor{Balance: last.Balance, Address: last.Address}.String()
+	}
+	jc.Encode(resp)
+}
+
+// checkSpan writes a 400 if the range [from, to] spans more than limit
+// blocks. A limit of zero allows any span.
+func checkSpan(jc jape.Context, from, to, limit uint64) bool {
+	if limit > 0 && to-from >= limit {
+		jc.Error(fmt.Errorf("range must not span more than %d blocks", limit), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// decodeAddressParam decodes the address path parameter, writing a 400 with a
+// description of the problem if it is malformed.
+func decodeAddressParam(jc jape.Context, param string) (types.Address, bool) {
+	addr, err := parseAddress(jc.PathParam(param))
+	if err != nil {
+		jc.Error(fmt.Errorf("invalid %s: %w", param, err), http.StatusBadRequest)
+		return types.Address{}, false
+	}
+	return addr, true
+}
+
+func (s *server) handleGETAddress(jc jape.Context) {
+	addr, ok := decodeAddressParam(jc, "address")
+	if !ok {
+		return
+	}
+
+	balance, foundation, err := s.store.AddressBalance(addr)
+	if errors.Is(err, index.ErrNotFound) {
+		jc.Error(fmt.Errorf("address %v has no indexed balance", addr), http.StatusNotFound)
+		return
+	} else if jc.Check("failed to get address balance", err) != nil {
+		return
+	}
+	jc.Encode(AddressBalanceResponse{
+		Address:    addr,
+		Balance:    balance,
+		Foundation: foundation,
+	})
+}
+
+func (s *server) handleGETMetrics(jc jape.Context) {
+	state, circulating, _, err := circulatingSupply(s.store, s.exclusions)
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	}
+	foundationTreasury, err := s.store.FoundationTreasury()
+	if jc.Check("failed to get foundation treasury", err) != nil {
+		return
+	}
+
+	metrics := []metric{
+		currencyGauge("sia_total_supply_hastings", "The total supply of Siacoin in hastings.", state.TotalSupply),
+		currencyGauge("sia_circulating_supply_hastings", "The circulating supply of Siacoin in hastings, excluding the foundation treasury and excluded addresses.", circulating),
+		currencyGauge("sia_burned_supply_hastings", "The supply of Siacoin that has been verifiably burned in hastings.", state.BurnedSupply),
+		currencyGauge("sia_foundation_treasury_hastings", "The value of the foundation treasury in hastings.", foundationTreasury),
+		uint64Gauge("sia_indexed_height", "The height of the last indexed block.", state.Index.Height),
+	}
+	if s.reorgs != nil {
+		applied, reverted, maxDepth := s.reorgs.Counts()
+		metrics = append(metrics,
+			uint64Counter("sia_blocks_applied_total", "The number of blocks applied by the indexer since it started.", applied),
+			uint64Counter("sia_blocks_reverted_total", "The number of blocks reverted by reorgs since the indexer started.", reverted),
+			uint64Gauge("sia_reorg_depth_max", "The maximum number of blocks reverted by a single reorg since the indexer started.", maxDepth),
+		)
+	}
+
+	jc.ResponseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(jc.ResponseWriter, metrics)
+}
+
+func (s *server) handleGETVersion(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	jc.Encode(VersionResponse{
+		Version:        build.Version(),
+		Commit:         build.Commit(),
+		BuildTime:      build.Time(),
+		WalletdAddress: s.walletdAddress,
+		Index:          state.Index,
+	})
+}
+
+// handleGETTxpoolSupply returns the change in supply the unconfirmed
+// transactions would apply if they were confirmed. Transactions only move
+// siacoins between addresses, so the total supply is only reduced by outputs
+// sent to the void address.
+func (s *server) handleGETTxpoolSupply(jc jape.Context) {
+	txns, v2txns, err := s.txpool.TxpoolTransactions()
+	if err != nil {
+		jc.Error(fmt.Errorf("failed to get txpool transactions: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	var burned types.Currency
+	for _, txn := range txns {
+		for _, sco := range txn.SiacoinOutputs {
+			if sco.Address == types.VoidAddress {
+				burned = burned.Add(sco.Value)
+			}
+		}
+	}
+	for _, txn := range v2txns {
+		for _, sco := range txn.SiacoinOutputs {
+			if sco.Address == types.VoidAddress {
+				burned = burned.Add(sco.Value)
+			}
+		}
+	}
+	jc.Encode(TxpoolSupplyResponse{
+		Transactions: len(txns) + len(v2txns),
+		Burned:       burned,
+	})
+}
+
+func (s *server) handleGETSyncProgress(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	tip, err := s.chainTip()
+	if err != nil {
+		jc.Error(fmt.Errorf("failed to get chain tip: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+	jc.Encode(SyncProgressResponse{
+		Height:  state.Index.Height,
+		Tip:     tip.Height,
+		Percent: index.SyncProgress(state.Index.Height, tip.Height),
+	})
+}
+
+func (s *server) handleGETHealth(jc jape.Context) {
+	state, err := s.store.State()
+	if jc.Check("failed to get state", err) != nil {
+		return
+	}
+	tip, err := s.chainTip()
+	if err != nil {
+		jc.Error(fmt.Errorf("failed to get chain tip: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	lag := blocksBehind(state, tip)
+	resp := HealthResponse{
+		Synced: lag <= s.maxHealthLag,
+		Lag:    lag,
+	}
+	if s.stalls != nil {
+		// a synced index that has stalled means walletd's tip is stuck
+		resp.Stalled, resp.SinceLastBlock = s.stalls.Stalled()
+	}
+	if !resp.Synced || resp.Stalled {
+		encodeStatus(jc, http.StatusServiceUnavailable, resp)
+		return
+	}
+	jc.Encode(resp)
+}
+
+// NewServer returns an http.Handler that serves the supply API.
+func NewServer(store Store, chain Chain, opts ...ServerOption) http.Handler {
+	s := &server{
+		store: store,
+		chain: chain,
+
+		maxHealthLag: 6,
+
+		log:             zap.NewNop(),
+		requestLogLevel: zap.DebugLevel,
+
+		protectedRoutes: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.hub = &supplyHub{store: store, exclusions: s.exclusions, log: s.log.Named("hub"), notifier: s.notifier}
+
+	routes := map[string]jape.Handler{
+		"GET /tip":     s.handleGETTip,
+		"GET /health":  s.handleGETHealth,
+		"GET /version": s.handleGETVersion,
+
+		"GET /sync/progress": s.handleGETSyncProgress,
+
+		"GET /supply":                  s.handleGETSupply,
+		"GET /supply/total":            s.handleGETSupplyTotal,
+		"GET /supply/circulating":      s.handleGETSupplyCirculating,
+		"GET /supply/burned":           s.handleGETSupplyBurned,
+		"GET /supply/burned/void":      s.handleGETSupplyBurnedVoid,
+		"GET /supply/burned/contracts": s.handleGETSupplyBurnedContracts,
+		"GET /supply/burned/history":   s.handleGETSupplyBurnedHistory,
+		"GET /supply/locked":           s.handleGETSupplyLocked,
+		"GET /supply/spendable":        s.handleGETSupplySpendable,
+		"GET /supply/immature":         s.handleGETSupplyImmature,
+		"GET /supply/max":              s.handleGETSupplyMax,
+		"GET /supply/block-rewards":    s.handleGETSupplyBlockRewards,
+		"GET /supply/inflation":        s.handleGETSupplyInflation,
+		"GET /supply/emission":         s.handleGETSupplyEmission,
+		"GET /supply/history":          s.handleGETSupplyHistory,
+		"GET /supply/history.csv":      s.handleGETSupplyHistoryCSV,
+
+		"GET /coingecko/supply": s.handleGETCoinGeckoSupply,
+		"GET /cmc/supply":       s.handleGETCMCSupply,
+
+		"GET /foundation/treasury":  s.handleGETFoundationTreasury,
+		"GET /foundation/subsidy":   s.handleGETFoundationSubsidy,
+		"GET /foundation/addresses": s.handleGETFoundationAddresses,
+
+		"GET /siafund/pool":   s.handleGETSiafundPool,
+		"GET /siafund/claims": s.handleGETSiafundClaims,
+
+		"GET /addresses/rich":     s.handleGETAddressesRich,
+		"GET /addresses/:address": s.handleGETAddress,
+
+		"GET /metrics": s.handleGETMetrics,
+
+		"GET /ws/supply":  s.handleGETWSSupply,
+		"GET /sse/supply": s.handleGETSSESupply,
+	}
+	for route, h := range routes {
+		if path := strings.Fields(route)[1]; s.protectedRoutes[path] {
+			routes[route] = s.requireAdminKey(h)
+		}
+	}
+	// httprouter does not allow a static segment next to a parameter, so
+	// the rich list is resolved by the address route. "rich" is not a valid
+	// address, so no address is shadowed. The handlers are merged after the
+	// admin key is applied, so each path is still protected separately.
+	richList, address := routes["GET /addresses/rich"], routes["GET /addresses/:address"]
+	delete(routes, "GET /addresses/rich")
+	routes["GET /addresses/:address"] = func(jc jape.Context) {
+		if jc.PathParam("address") == "rich" {
+			richList(jc)
+			return
+		}
+		address(jc)
+	}
+	if s.txpool != nil {
+		routes["GET /txpool/supply"] = s.handleGETTxpoolSupply
+	}
+	if s.reindexer != nil {
+		routes["POST /admin/reindex"] = s.requireAdminKey(s.handlePOSTAdminReindex)
+	}
+	if s.maintainer != nil {
+		routes["POST /admin/vacuum"] = s.requireAdminKey(s.handlePOSTAdminVacuum)
+		routes["POST /admin/backup"] = s.requireAdminKey(s.handlePOSTAdminBackup)
+	}
+	if s.auditor != nil {
+		routes["GET /admin/audit"] = s.requireAdminKey(s.handleGETAdminAudit)
+	}
+	if s.exclusions != nil {
+		routes["POST /admin/exclusions/reload"] = s.requireAdminKey(s.handlePOSTAdminExclusionsReload)
+	}
+
+	var h http.Handler = jape.Mux(routes)
+	h = jsonErrors(h)
+	h = compressResponses(h)
+	h = allowCORS(s.corsOrigins, h)
+	return logRequests(s.log, s.requestLogLevel, h)
+}
@@ -0,0 +1,1952 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"lukechampine.com/frand"
+)
+
+// mockStore is a Store that returns a fixed state.
+type mockStore struct {
+	state    index.State
+	treasury types.Currency
+	locked   types.Currency
+	history  map[uint64]index.State
+	// balances is sorted in rich list order
+	balances   []index.AddressBalance
+	foundation []index.AddressBalance
+}
+
+func (ms *mockStore) State() (index.State, error) { return ms.state, nil }
+
+func (ms *mockStore) FoundationTreasury() (types.Currency, error) { return ms.treasury, nil }
+
+func (ms *mockStore) Supply() (index.State, types.Currency, error) {
+	return ms.state, ms.treasury, nil
+}
+
+func (ms *mockStore) SupplyExcluding(addrs []types.Address) (index.State, types.Currency, error) {
+	excluded := ms.treasury
+	for _, addr := range addrs {
+		for _, b := range ms.balances {
+			if b.Address == addr {
+				excluded = excluded.Add(b.Balance)
+			}
+		}
+	}
+	return ms.state, excluded, nil
+}
+
+func (ms *mockStore) LockedSupply() (index.State, types.Currency, error) {
+	return ms.state, ms.locked, nil
+}
+
+func (ms *mockStore) RichList(limit, offset int) ([]index.AddressBalance, error) {
+	if offset > len(ms.balances) {
+		return nil, nil
+	}
+	balances := ms.balances[offset:]
+	if len(balances) > limit {
+		balances = balances[:limit]
+	}
+	return balances, nil
+}
+
+func (ms *mockStore) RichListAfter(balance types.Currency, address types.Address, limit int) ([]index.AddressBalance, error) {
+	for i, b := range ms.balances {
+		if b.Balance == balance && b.Address == address {
+			return ms.RichList(limit, i+1)
+		}
+	}
+	return nil, nil
+}
+
+func (ms *mockStore) FoundationAddresses() ([]index.AddressBalance, error) {
+	return ms.foundation, nil
+}
+
+func (ms *mockStore) AddressBalance(addr types.Address) (types.Currency, bool, error) {
+	for _, b := range ms.balances {
+		if b.Address == addr {
+			return b.Balance, false, nil
+		}
+	}
+	return types.ZeroCurrency, false, index.ErrNotFound
+}
+
+func (ms *mockStore) SupplyAtHeight(height uint64) (index.State, error) {
+	state, ok := ms.history[height]
+	if !ok {
+		return index.State{}, index.ErrNotFound
+	}
+	return state, nil
+}
+
+func (ms *mockStore) SupplyAtTime(t time.Time) (state index.State, _ error) {
+	found := false
+	for _, s := range ms.history {
+		if s.Timestamp.After(t) {
+			continue
+		} else if !found || s.Timestamp.After(state.Timestamp) || (s.Timestamp.Equal(state.Timestamp) && s.Index.Height > state.Index.Height) {
+			state, found = s, true
+		}
+	}
+	if !found {
+		return index.State{}, index.ErrNotFound
+	}
+	return state, nil
+}
+
+func (ms *mockStore) SupplyHistory(from, to uint64, limit int) (history []index.State, _ error) {
+	for _, state := range ms.history {
+		if state.Index.Height >= from && state.Index.Height <= to {
+			history = append(history, state)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Index.Height < history[j].Index.Height })
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+func (ms *mockStore) SampledSupplyHistory(from, to, step uint64, limit int) (history []index.State, _ error) {
+	all, _ := ms.SupplyHistory(from, to, len(ms.history))
+	for _, state := range all {
+		if (state.Index.Height-from)%step == 0 && len(history) < limit {
+			history = append(history, state)
+		}
+	}
+	return history, nil
+}
+
+// lockedStore is a mockStore whose supply can be changed while the server is
+// running.
+type lockedStore struct {
+	mockStore
+	mu sync.Mutex
+}
+
+func (ls *lockedStore) Supply() (index.State, types.Currency, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.mockStore.Supply()
+}
+
+func (ls *lockedStore) setState(state index.State) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.state = state
+}
+
+// mockChain is a Chain with a fixed tip.
+type mockChain struct {
+	tip types.ChainIndex
+}
+
+func (mc mockChain) ConsensusTip() (types.ChainIndex, error) { return mc.tip, nil }
+
+// mockTxpool is a Txpool with a fixed set of transactions.
+type mockTxpool struct {
+	txns   []types.Transaction
+	v2txns []types.V2Transaction
+}
+
+func (mt mockTxpool) TxpoolTransactions() ([]types.Transaction, []types.V2Transaction, error) {
+	return mt.txns, mt.v2txns, nil
+}
+
+func TestErrorResponses(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			TotalSupply:       types.Siacoins(100),
+			CirculatingSupply: types.Siacoins(50),
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	tests := []struct {
+		path    string
+		status  int
+		message string
+	}{
+		{"/supply/total?units=foo", http.StatusBadRequest, `unknown units "foo"`},
+		{"/supply/history", http.StatusBadRequest, "height or time is required"},
+		{"/supply/history?height=10&time=2024-01-01T00:00:00Z", http.StatusBadRequest, "height and time cannot both be set"},
+		{"/supply/history?time=2024-01-01T00:00:00Z", http.StatusNotFound, "no supply history at or before 2024-01-01T00:00:00Z"},
+		{"/supply/history?height=10", http.StatusNotFound, "no supply history at height 10"},
+		{"/supply/emission", http.StatusBadRequest, "window is required"},
+		{"/supply?format=xml", http.StatusBadRequest, `unknown format "xml"`},
+		{"/supply/burned/history?step=0", http.StatusBadRequest, "step must be positive"},
+		{"/supply/emission?window=0", http.StatusBadRequest, "window must be positive"},
+		{"/addresses/rich?limit=0", http.StatusBadRequest, "limit must be between 1 and 500"},
+		{"/addresses/foo", http.StatusBadRequest, "invalid address: address must be 76 hex characters, got 3"},
+		// unknown supply types are not routed, rather than returning an
+		// empty response
+		{"/supply/foo", http.StatusNotFound, "404 page not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, rec.Code)
+			} else if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("expected JSON content type, got %q", ct)
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			} else if resp.Error != test.message {
+				t.Fatalf("expected error %q, got %q", test.message, resp.Error)
+			}
+		})
+	}
+
+	// successful responses are unchanged
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/total", nil))
+	var total float64
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&total); err != nil {
+		t.Fatal(err)
+	} else if total != 100 {
+		t.Fatalf("expected total supply 100, got %v", total)
+	}
+}
+
+func TestSupplyHistoryCSV(t *testing.T) {
+	store := &mockStore{history: make(map[uint64]index.State)}
+	for height := uint64(0); height < csvBatchSize+10; height++ {
+		store.history[height] = index.State{
+			Index:             types.ChainIndex{Height: height, ID: types.BlockID{byte(height)}},
+			TotalSupply:       types.Siacoins(uint32(height)),
+			CirculatingSupply: types.Siacoins(uint32(height)).Div64(2),
+			BurnedSupply:      types.NewCurrency64(height),
+		}
+	}
+	srv := NewServer(store, mockChain{})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/history.csv?from=5&units=hastings", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("expected CSV content type, got %q", ct)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != csvBatchSize+6 {
+		t.Fatalf("expected %d records, got %d", csvBatchSize+6, len(records))
+	}
+	header := []string{"height", "block_id", "total_supply", "circulating_supply", "burned_supply"}
+	for i := range header {
+		if records[0][i] != header[i] {
+			t.Fatalf("expected header %v, got %v", header, records[0])
+		}
+	}
+	for i, record := range records[1:] {
+		state := store.history[uint64(i+5)]
+		expected := []string{
+			strconv.FormatUint(state.Index.Height, 10),
+			state.Index.ID.String(),
+			state.TotalSupply.ExactString(),
+			state.CirculatingSupply.ExactString(),
+			state.BurnedSupply.ExactString(),
+		}
+		for j := range expected {
+			if record[j] != expected[j] {
+				t.Fatalf("expected record %v, got %v", expected, record)
+			}
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/history.csv?from=10&to=5", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestSupplyValues(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			TotalSupply:       types.Siacoins(100),
+			CirculatingSupply: types.Siacoins(80),
+			BurnedSupply:      types.Siacoins(3),
+			SiafundPool:       types.Siacoins(7),
+		},
+		treasury: types.Siacoins(30),
+	}
+	srv := NewServer(store, mockChain{})
+
+	tests := []struct {
+		path  string
+		value float64
+	}{
+		{"/supply/total", 100},
+		{"/supply/circulating", 50},
+		{"/supply/burned", 3},
+		{"/siafund/pool", 7},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			var value float64
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			} else if err := json.NewDecoder(rec.Body).Decode(&value); err != nil {
+				t.Fatal(err)
+			} else if value != test.value {
+				t.Fatalf("expected %v, got %v", test.value, value)
+			}
+		})
+	}
+	// the combined response includes the same values
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply", nil))
+	var resp map[string]any
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp["total_supply"] != 100.0 || resp["circulating_supply"] != 50.0 || resp["siafund_pool"] != 7.0 {
+		t.Fatalf("unexpected response %v", resp)
+	} else if v, ok := resp["max_supply"]; !ok || v != nil {
+		t.Fatalf("expected null max supply, got %v", v)
+	}
+}
+
+func TestSupplyHistoryTime(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	store := &mockStore{history: make(map[uint64]index.State)}
+	for height := uint64(0); height < 10; height++ {
+		store.history[height] = index.State{
+			Index:       types.ChainIndex{Height: height},
+			Timestamp:   start.Add(time.Duration(height) * 10 * time.Minute),
+			TotalSupply: types.Siacoins(uint32(height)),
+		}
+	}
+	srv := NewServer(store, mockChain{})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/history?time="+url.QueryEscape(start.Add(35*time.Minute).Format(time.RFC3339)), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	var resp SupplyHistoryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Index.Height != 3 {
+		t.Fatalf("expected height 3, got %d", resp.Index.Height)
+	} else if !resp.TotalSupply.Equals(types.Siacoins(3)) {
+		t.Fatalf("expected total supply %v, got %v", types.Siacoins(3), resp.TotalSupply)
+	}
+}
+
+func TestCMCSupply(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			TotalSupply:       types.Siacoins(100),
+			CirculatingSupply: types.Siacoins(80),
+		},
+		treasury: types.Siacoins(30),
+	}
+	// exact strings must not be used, even when they are the default
+	srv := NewServer(store, mockChain{}, WithExactDecimals(true), WithMaxSupply(1000))
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cmc/supply?exact=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if len(resp) != 2 {
+		t.Fatalf("expected exactly 2 keys, got %v", resp)
+	} else if v, ok := resp["circulating_supply"].(float64); !ok || v != 50 {
+		t.Fatalf("expected circulating supply 50, got %#v", resp["circulating_supply"])
+	} else if v, ok := resp["total_supply"].(float64); !ok || v != 100 {
+		t.Fatalf("expected total supply 100, got %#v", resp["total_supply"])
+	}
+}
+
+func TestSupplyMeta(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			Index:       types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+			TotalSupply: types.Siacoins(100),
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/total?meta=true", nil))
+	var resp struct {
+		Value   float64       `json:"value"`
+		Height  uint64        `json:"height"`
+		BlockID types.BlockID `json:"block_id"` //nolint:tagliatelle
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Value != 100 || resp.Height != 10 || resp.BlockID != store.state.Index.ID {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestSupplyLocked(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			CirculatingSupply: types.Siacoins(100),
+		},
+		treasury: types.Siacoins(20),
+		locked:   types.Siacoins(30),
+	}
+	srv := NewServer(store, mockChain{})
+
+	tests := []struct {
+		path  string
+		value float64
+	}{
+		{"/supply/locked", 30},
+		// the spendable supply includes the foundation treasury
+		{"/supply/spendable", 70},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			var value float64
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			} else if err := json.NewDecoder(rec.Body).Decode(&value); err != nil {
+				t.Fatal(err)
+			} else if value != test.value {
+				t.Fatalf("expected %v, got %v", test.value, value)
+			}
+		})
+	}
+}
+
+func TestSupplyExact(t *testing.T) {
+	const exact = "123456789.123456789012345678901234"
+	c, err := types.ParseCurrency(exact + " SC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := index.State{
+		TotalSupply:       c,
+		CirculatingSupply: c,
+	}
+	store := &mockStore{
+		state:   state,
+		history: map[uint64]index.State{0: state},
+	}
+
+	// get requests path from srv and returns the trimmed body
+	get := func(srv http.Handler, path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+		}
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	// floats are the default
+	srv := NewServer(store, mockChain{})
+	if body := get(srv, "/supply/total"); body != "123456789.12345679" {
+		t.Fatalf("expected float, got %s", body)
+	} else if body := get(srv, "/supply/total?exact=true"); body != `"`+exact+`"` {
+		t.Fatalf("expected exact string, got %s", body)
+	} else if body := get(srv, "/supply/total?exact=true&decimals=2"); body != `"123456789.12"` {
+		t.Fatalf("expected decimals to take precedence, got %s", body)
+	}
+
+	var resp struct {
+		TotalSupply       any `json:"total_supply"`       //nolint:tagliatelle
+		CirculatingSupply any `json:"circulating_supply"` //nolint:tagliatelle
+	}
+	for _, path := range []string{"/supply?exact=true", "/coingecko/supply?exact=true"} {
+		if err := json.Unmarshal([]byte(get(srv, path)), &resp); err != nil {
+			t.Fatal(err)
+		} else if resp.TotalSupply != exact || resp.CirculatingSupply != exact {
+			t.Fatalf("%s: expected exact strings, got %+v", path, resp)
+		}
+	}
+	if body := get(srv, "/supply/history.csv?exact=true"); !strings.Contains(body, exact) {
+		t.Fatalf("expected exact CSV values, got %s", body)
+	}
+
+	// the server default can be overridden by clients
+	srv = NewServer(store, mockChain{}, WithExactDecimals(true))
+	if body := get(srv, "/supply/circulating"); body != `"`+exact+`"` {
+		t.Fatalf("expected exact string, got %s", body)
+	} else if body := get(srv, "/supply/circulating?exact=false"); body != "123456789.12345679" {
+		t.Fatalf("expected float, got %s", body)
+	} else if err := json.Unmarshal([]byte(get(srv, "/supply")), &resp); err != nil {
+		t.Fatal(err)
+	} else if resp.TotalSupply != exact {
+		t.Fatalf("expected exact total supply, got %v", resp.TotalSupply)
+	}
+}
+
+func TestSupplyDecimals(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			TotalSupply: types.Siacoins(100).Add(types.Siacoins(1).Div64(3)),
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"decimals=2", http.StatusOK, `"100.33"`},
+		{"decimals=0", http.StatusOK, `"100"`},
+		{"decimals=24", http.StatusOK, `"100.333333333333333333333333"`},
+		{"decimals=25", http.StatusBadRequest, ""},
+		{"decimals=-1", http.StatusBadRequest, ""},
+		{"decimals=2&units=hastings", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/total?"+test.query, nil))
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, rec.Code)
+			} else if test.body != "" && strings.TrimSpace(rec.Body.String()) != test.body {
+				t.Fatalf("expected body %s, got %s", test.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSupplyInflation(t *testing.T) {
+	state := func(height uint64, total types.Currency) index.State {
+		return index.State{
+			Index:       types.ChainIndex{Height: height, ID: types.BlockID{byte(height)}},
+			Timestamp:   time.Unix(int64(height)*600, 0).UTC(),
+			TotalSupply: total,
+		}
+	}
+	getInflation := func(t *testing.T, store *mockStore) (resp InflationResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		NewServer(store, mockChain{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/inflation", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// a full year of history
+	tip := state(blocksPerYear+100, types.Siacoins(110))
+	store := &mockStore{
+		state: tip,
+		history: map[uint64]index.State{
+			50:               state(50, types.Siacoins(90)),
+			100:              state(100, types.Siacoins(100)),
+			tip.Index.Height: tip,
+		},
+	}
+	resp := getInflation(t, store)
+	if resp.Partial {
+		t.Fatal("expected full year of history")
+	} else if resp.StartHeight != 100 || resp.EndHeight != tip.Index.Height {
+		t.Fatalf("expected heights 100 to %d, got %d to %d", tip.Index.Height, resp.StartHeight, resp.EndHeight)
+	} else if !resp.StartTimestamp.Equal(store.history[100].Timestamp) || !resp.EndTimestamp.Equal(tip.Timestamp) {
+		t.Fatalf("unexpected timestamps %v to %v", resp.StartTimestamp, resp.EndTimestamp)
+	} else if resp.Rate != 0.1 {
+		t.Fatalf("expected rate 0.1, got %v", resp.Rate)
+	}
+
+	// history pruned to less than a year
+	delete(store.history, 50)
+	delete(store.history, 100)
+	store.history[1000] = state(1000, types.Siacoins(100))
+	resp = getInflation(t, store)
+	if !resp.Partial {
+		t.Fatal("expected partial history")
+	} else if resp.StartHeight != 1000 {
+		t.Fatalf("expected start height 1000, got %d", resp.StartHeight)
+	} else if resp.Rate != 0.1 {
+		t.Fatalf("expected rate 0.1, got %v", resp.Rate)
+	}
+}
+
+func TestSupplyEmission(t *testing.T) {
+	state := func(height uint64, total types.Currency) index.State {
+		return index.State{
+			Index:       types.ChainIndex{Height: height, ID: types.BlockID{byte(height)}},
+			TotalSupply: total,
+		}
+	}
+	getEmission := func(t *testing.T, store *mockStore, query string) (resp EmissionResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		NewServer(store, mockChain{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/emission?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	tip := state(200, types.Siacoins(110))
+	store := &mockStore{
+		state: tip,
+		history: map[uint64]index.State{
+			50:               state(50, types.Siacoins(90)),
+			100:              state(100, types.Siacoins(100)),
+			tip.Index.Height: tip,
+		},
+	}
+	resp := getEmission(t, store, "window=100")
+	if resp.Partial {
+		t.Fatal("expected full window of history")
+	} else if resp.Window != 100 || resp.StartHeight != 100 || resp.EndHeight != tip.Index.Height {
+		t.Fatalf("expected heights 100 to %d, got %d to %d", tip.Index.Height, resp.StartHeight, resp.EndHeight)
+	} else if resp.Emission != types.Siacoins(10).ExactString() {
+		t.Fatalf("expected emission %v, got %v", types.Siacoins(10).ExactString(), resp.Emission)
+	} else if resp.EmissionSC != 10.0 {
+		t.Fatalf("expected 10 SC, got %v", resp.EmissionSC)
+	}
+
+	// the window extends past the indexed history
+	resp = getEmission(t, store, "window=1000&exact=true")
+	if !resp.Partial {
+		t.Fatal("expected partial history")
+	} else if resp.StartHeight != 50 {
+		t.Fatalf("expected start height 50, got %d", resp.StartHeight)
+	} else if resp.EmissionSC != "20" {
+		t.Fatalf("expected exact emission \"20\", got %v", resp.EmissionSC)
+	}
+
+	// burns can reduce the total supply over the window
+	store.state = state(300, types.Siacoins(105))
+	store.history[300] = store.state
+	resp = getEmission(t, store, "window=100")
+	if resp.Emission != "-"+types.Siacoins(5).ExactString() {
+		t.Fatalf("expected emission -%v, got %v", types.Siacoins(5).ExactString(), resp.Emission)
+	} else if resp.EmissionSC != -5.0 {
+		t.Fatalf("expected -5 SC, got %v", resp.EmissionSC)
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	state := index.State{
+		Index:             types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+		Timestamp:         time.Unix(1700000000, 0).UTC(),
+		TotalSupply:       types.Siacoins(100),
+		CirculatingSupply: types.Siacoins(80),
+		BurnedSupply:      types.Siacoins(1),
+		SiafundPool:       types.Siacoins(2),
+	}
+	store := &mockStore{
+		state:    state,
+		treasury: types.Siacoins(30),
+		history:  map[uint64]index.State{10: state},
+	}
+	srv := NewServer(store, mockChain{})
+
+	get := func(t *testing.T, path string) (string, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		return rec.Header().Get("Content-Type"), rec.Body.String()
+	}
+
+	tests := []struct {
+		path   string
+		values map[string]string
+	}{
+		{"/supply", map[string]string{
+			"height":             "10",
+			"total_supply":       "100",
+			"circulating_supply": "50",
+			"siafund_pool":       "2",
+		}},
+		{"/supply/history?height=10", map[string]string{
+			"height":                      "10",
+			"total_supply_hastings":       types.Siacoins(100).ExactString(),
+			"circulating_supply_hastings": types.Siacoins(80).ExactString(),
+			"burned_supply_hastings":      types.Siacoins(1).ExactString(),
+		}},
+	}
+	for _, test := range tests {
+		sep := "?"
+		if strings.Contains(test.path, "?") {
+			sep = "&"
+		}
+
+		t.Run(test.path+" json", func(t *testing.T) {
+			ct, body := get(t, test.path+sep+"format=json")
+			if ct != "application/json" {
+				t.Fatalf("expected JSON content type, got %q", ct)
+			}
+			var defaultBody string
+			if _, defaultBody = get(t, test.path); body != defaultBody {
+				t.Fatalf("expected the default JSON response %q, got %q", defaultBody, body)
+			} else if !json.Valid([]byte(body)) {
+				t.Fatalf("invalid JSON %q", body)
+			}
+		})
+
+		t.Run(test.path+" csv", func(t *testing.T) {
+			ct, body := get(t, test.path+sep+"format=csv")
+			if ct != "text/csv" {
+				t.Fatalf("expected CSV content type, got %q", ct)
+			}
+			rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			} else if len(rows) != 2 {
+				t.Fatalf("expected header and 1 row, got %d rows", len(rows))
+			}
+			for i, name := range rows[0] {
+				if expected, ok := test.values[name]; ok && rows[1][i] != expected {
+					t.Fatalf("expected %s %q, got %q", name, expected, rows[1][i])
+				} else if name == "block_id" && rows[1][i] != state.Index.ID.String() {
+					t.Fatalf("expected block ID %v, got %q", state.Index.ID, rows[1][i])
+				}
+			}
+		})
+
+		t.Run(test.path+" prometheus", func(t *testing.T) {
+			ct, body := get(t, test.path+sep+"format=prometheus")
+			if !strings.HasPrefix(ct, "text/plain") {
+				t.Fatalf("expected text content type, got %q", ct)
+			}
+			samples := make(map[string]string)
+			for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+				name, value, ok := strings.Cut(line, " ")
+				if !ok {
+					t.Fatalf("invalid sample %q", line)
+				} else if _, err := strconv.ParseFloat(value, 64); err != nil {
+					t.Fatalf("invalid value for %s: %v", name, err)
+				}
+				samples[name] = value
+			}
+			for name, expected := range test.values {
+				if samples["sia_"+name] != expected {
+					t.Fatalf("expected sia_%s %q, got %q", name, expected, samples["sia_"+name])
+				}
+			}
+		})
+	}
+}
+
+func TestSupplyBurnedHistory(t *testing.T) {
+	const tip = 2500
+	store := &mockStore{history: make(map[uint64]index.State)}
+	for height := uint64(0); height <= tip; height++ {
+		store.history[height] = index.State{
+			Index:        types.ChainIndex{Height: height},
+			BurnedSupply: types.NewCurrency64(height),
+		}
+	}
+	store.state = store.history[tip]
+	srv := NewServer(store, mockChain{})
+
+	getHistory := func(query string) (resp BurnedHistoryResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/burned/history?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	checkPoints := func(resp BurnedHistoryResponse, from uint64, n int) {
+		t.Helper()
+		if len(resp.Points) != n {
+			t.Fatalf("expected %d points, got %d", n, len(resp.Points))
+		}
+		for i, p := range resp.Points {
+			if height := from + uint64(i)*resp.Step; p.Height != height || !p.BurnedSupply.Equals(types.NewCurrency64(height)) {
+				t.Fatalf("expected burned supply %d at height %d, got %v at %d", height, height, p.BurnedSupply, p.Height)
+			}
+		}
+	}
+
+	resp := getHistory("from=100&to=1100&step=100")
+	if resp.Step != 100 {
+		t.Fatalf("expected step 100, got %d", resp.Step)
+	}
+	checkPoints(resp, 100, 11)
+
+	// the step is increased to keep the number of points under the cap
+	resp = getHistory("")
+	if resp.Step != 3 {
+		t.Fatalf("expected step 3, got %d", resp.Step)
+	}
+	checkPoints(resp, 0, 834)
+
+	// the range is limited to the indexed height
+	resp = getHistory("from=2400&to=5000&step=50")
+	checkPoints(resp, 2400, 3)
+
+	// a range past the indexed height is empty
+	if resp = getHistory("from=3000"); len(resp.Points) != 0 {
+		t.Fatalf("expected no points, got %d", len(resp.Points))
+	}
+}
+
+func TestMaxSpan(t *testing.T) {
+	store := &mockStore{history: make(map[uint64]index.State)}
+	for height := uint64(0); height <= 200; height++ {
+		store.history[height] = index.State{Index: types.ChainIndex{Height: height}}
+	}
+	store.state = store.history[200]
+	srv := NewServer(store, mockChain{}, WithMaxRangeSpan(50), WithMaxExportSpan(100))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/supply/burned/history?from=0&to=49", http.StatusOK},
+		{"/supply/burned/history?from=0&to=50", http.StatusBadRequest},
+		// the range is limited to the indexed height before it is checked
+		{"/supply/burned/history?from=151", http.StatusOK},
+		{"/supply/burned/history", http.StatusBadRequest},
+		{"/supply/history.csv?from=0&to=99", http.StatusOK},
+		{"/supply/history.csv?from=101", http.StatusOK},
+		{"/supply/history.csv?from=100", http.StatusBadRequest},
+		{"/supply/history.csv", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.path, test.status, rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/supply/history.csv", nil))
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if expected := "range must not span more than 100 blocks"; resp.Error != expected {
+		t.Fatalf("expected error %q, got %q", expected, resp.Error)
+	}
+}
+
+func TestSupplyContentNegotiation(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			Index:       types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+			TotalSupply: types.Siacoins(100),
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	tests := []struct {
+		accept      string
+		query       string
+		contentType string
+		body        string
+	}{
+		{"", "", "application/json", "100"},
+		{"*/*", "", "application/json", "100"},
+		{"application/json", "", "application/json", "100"},
+		{"text/html, */*;q=0.8", "", "application/json", "100"},
+		{"text/plain", "", "text/plain; charset=utf-8", "100"},
+		{"text/plain", "units=hastings", "text/plain; charset=utf-8", types.Siacoins(100).ExactString()},
+		{"text/plain", "decimals=2", "text/plain; charset=utf-8", "100.00"},
+		{"application/json;q=0.5, text/plain", "", "text/plain; charset=utf-8", "100"},
+		{"text/plain;q=0, application/json", "", "application/json", "100"},
+		{"application/vnd.sia.value+json", "", "application/vnd.sia.value+json", `{"value":100,"height":10,"block_id":"` + types.BlockID{1}.String() + `"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.accept+"?"+test.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/supply/total?"+test.query, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			} else if ct := rec.Header().Get("Content-Type"); ct != test.contentType {
+				t.Fatalf("expected content type %q, got %q", test.contentType, ct)
+			}
+
+			body := strings.TrimSpace(rec.Body.String())
+			if strings.HasSuffix(test.contentType, "json") && strings.HasPrefix(test.body, "{") {
+				var buf bytes.Buffer
+				if err := json.Compact(&buf, []byte(body)); err != nil {
+					t.Fatal(err)
+				}
+				body = buf.String()
+			}
+			if body != test.body {
+				t.Fatalf("expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestTip(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			Index: types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+		},
+	}
+	chain := mockChain{tip: types.ChainIndex{Height: 20, ID: types.BlockID{2}}}
+
+	getTip := func(srv http.Handler) (resp TipResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tip", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	resp := getTip(NewServer(store, chain, WithMaxHealthLag(5)))
+	if resp.Index != store.state.Index || resp.ChainTip != chain.tip {
+		t.Fatalf("unexpected indices %v, %v", resp.Index, resp.ChainTip)
+	} else if resp.Synced || resp.BlocksBehind != 10 {
+		t.Fatalf("expected 10 blocks behind and not synced, got %+v", resp)
+	}
+
+	resp = getTip(NewServer(store, chain, WithMaxHealthLag(10)))
+	if !resp.Synced || resp.BlocksBehind != 10 {
+		t.Fatalf("expected 10 blocks behind and synced, got %+v", resp)
+	}
+}
+
+// mockStallMonitor is a StallMonitor with a fixed result.
+type mockStallMonitor struct {
+	stalled bool
+	since   time.Duration
+}
+
+func (m mockStallMonitor) Stalled() (bool, time.Duration) { return m.stalled, m.since }
+
+func TestHealthStalled(t *testing.T) {
+	tip := types.ChainIndex{Height: 10, ID: types.BlockID{1}}
+	store := &mockStore{state: index.State{Index: tip}}
+	chain := mockChain{tip: tip}
+
+	getHealth := func(srv http.Handler, status int) (resp HealthResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if rec.Code != status {
+			t.Fatalf("expected status %d, got %d", status, rec.Code)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if resp := getHealth(NewServer(store, chain, WithStallMonitor(mockStallMonitor{since: time.Minute})), http.StatusOK); !resp.Synced || resp.Stalled || resp.SinceLastBlock != time.Minute {
+		t.Fatalf("expected synced and not stalled, got %+v", resp)
+	}
+
+	// a synced index is unhealthy if the chain has stalled
+	if resp := getHealth(NewServer(store, chain, WithStallMonitor(mockStallMonitor{stalled: true, since: time.Hour})), http.StatusServiceUnavailable); !resp.Synced || !resp.Stalled || resp.SinceLastBlock != time.Hour {
+		t.Fatalf("expected synced and stalled, got %+v", resp)
+	}
+}
+
+// mockReorgCounter is a ReorgCounter with fixed counts.
+type mockReorgCounter struct {
+	applied, reverted, maxDepth uint64
+}
+
+func (m mockReorgCounter) Counts() (uint64, uint64, uint64) { return m.applied, m.reverted, m.maxDepth }
+
+func TestMetricsReorgs(t *testing.T) {
+	getMetrics := func(srv http.Handler) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// the reorg metrics are only reported with a counter
+	if body := getMetrics(NewServer(&mockStore{}, mockChain{})); strings.Contains(body, "sia_blocks_reverted_total") {
+		t.Fatalf("unexpected reorg metrics %q", body)
+	}
+
+	body := getMetrics(NewServer(&mockStore{}, mockChain{}, WithReorgCounter(mockReorgCounter{applied: 100, reverted: 7, maxDepth: 4})))
+	for _, line := range []string{
+		"# TYPE sia_blocks_applied_total counter",
+		"sia_blocks_applied_total 100",
+		"# TYPE sia_blocks_reverted_total counter",
+		"sia_blocks_reverted_total 7",
+		"# TYPE sia_reorg_depth_max gauge",
+		"sia_reorg_depth_max 4",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expected %q in %q", line, body)
+		}
+	}
+}
+
+func TestSyncProgress(t *testing.T) {
+	tests := []struct {
+		height, tip uint64
+		percent     float64
+	}{
+		{0, 0, 100},
+		{0, 200, 0},
+		{50, 200, 25},
+		{200, 200, 100},
+		// the cached tip may be older than the index
+		{210, 200, 100},
+	}
+	for _, test := range tests {
+		store := &mockStore{state: index.State{Index: types.ChainIndex{Height: test.height}}}
+		srv := NewServer(store, mockChain{tip: types.ChainIndex{Height: test.tip}})
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sync/progress", nil))
+		var resp SyncProgressResponse
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		} else if resp.Height != test.height || resp.Tip != test.tip || resp.Percent != test.percent {
+			t.Fatalf("expected %d/%d (%v%%), got %+v", test.height, test.tip, test.percent, resp)
+		}
+	}
+}
+
+func TestTxpoolSupply(t *testing.T) {
+	store := &mockStore{}
+	tp := mockTxpool{
+		txns: []types.Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{
+				{Address: types.VoidAddress, Value: types.Siacoins(1)},
+				{Address: frand.Entropy256(), Value: types.Siacoins(100)},
+			},
+		}},
+		v2txns: []types.V2Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{
+				{Address: types.VoidAddress, Value: types.Siacoins(2)},
+			},
+		}},
+	}
+
+	// the route is only registered with a txpool
+	rec := httptest.NewRecorder()
+	NewServer(store, mockChain{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/txpool/supply", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	NewServer(store, mockChain{}, WithTxpool(tp)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/txpool/supply", nil))
+	var resp TxpoolSupplyResponse
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Transactions != 2 {
+		t.Fatalf("expected 2 transactions, got %d", resp.Transactions)
+	} else if !resp.Burned.Equals(types.Siacoins(3)) {
+		t.Fatalf("expected 3 SC burned, got %v", resp.Burned)
+	}
+}
+
+func TestWSSupply(t *testing.T) {
+	store := &lockedStore{
+		mockStore: mockStore{
+			state: index.State{
+				Index:             types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+				TotalSupply:       types.Siacoins(100),
+				CirculatingSupply: types.Siacoins(50),
+			},
+			treasury: types.Siacoins(10),
+		},
+	}
+	srv := httptest.NewServer(NewServer(store, mockChain{}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/supply", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	// the current supply is sent on connect
+	var u SupplyUpdate
+	if err := wsjson.Read(ctx, conn, &u); err != nil {
+		t.Fatal(err)
+	} else if u.Index != store.state.Index || !u.TotalSupply.Equals(types.Siacoins(100)) || !u.CirculatingSupply.Equals(types.Siacoins(40)) {
+		t.Fatalf("unexpected initial update %+v", u)
+	}
+
+	// an update is sent when a new block is indexed
+	next := index.State{
+		Index:             types.ChainIndex{Height: 11, ID: types.BlockID{2}},
+		TotalSupply:       types.Siacoins(110),
+		CirculatingSupply: types.Siacoins(60),
+	}
+	store.setState(next)
+	if err := wsjson.Read(ctx, conn, &u); err != nil {
+		t.Fatal(err)
+	} else if u.Index != next.Index || !u.TotalSupply.Equals(types.Siacoins(110)) || !u.CirculatingSupply.Equals(types.Siacoins(50)) {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func TestWSSupplyShutdown(t *testing.T) {
+	store := &lockedStore{
+		mockStore: mockStore{
+			state: index.State{Index: types.ChainIndex{Height: 10, ID: types.BlockID{1}}},
+		},
+	}
+	serverCtx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	srv := httptest.NewUnstartedServer(NewServer(store, mockChain{}))
+	srv.Config.BaseContext = func(net.Listener) context.Context { return serverCtx }
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/supply", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	var u SupplyUpdate
+	if err := wsjson.Read(ctx, conn, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is closed when the server shuts down
+	shutdown()
+	if err := wsjson.Read(ctx, conn, &u); websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Fatalf("expected close status %v, got %v", websocket.StatusGoingAway, err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	store := &lockedStore{
+		mockStore: mockStore{
+			state: index.State{Index: types.ChainIndex{Height: 10, ID: types.BlockID{1}}},
+		},
+	}
+	n := NewNotifier()
+	srv := httptest.NewServer(NewServer(store, mockChain{}, WithNotifier(n)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/supply", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	var u SupplyUpdate
+	if err := wsjson.Read(ctx, conn, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	// with a notifier, the store is not polled, so the update is sent
+	// immediately after Notify instead of on the next poll
+	next := index.State{Index: types.ChainIndex{Height: 11, ID: types.BlockID{2}}}
+	store.setState(next)
+	start := time.Now()
+	n.Notify(next)
+	if err := wsjson.Read(ctx, conn, &u); err != nil {
+		t.Fatal(err)
+	} else if u.Index != next.Index {
+		t.Fatalf("expected index %v, got %v", next.Index, u.Index)
+	} else if time.Since(start) >= supplyPollInterval {
+		t.Fatal("expected update before the poll interval")
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func TestSSESupply(t *testing.T) {
+	store := &lockedStore{
+		mockStore: mockStore{
+			state: index.State{
+				Index:       types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+				TotalSupply: types.Siacoins(100),
+			},
+		},
+	}
+	srv := httptest.NewServer(NewServer(store, mockChain{}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/sse/supply", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// small events must not be held back by compression
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream, got %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	readEvent := func() (u SupplyUpdate) {
+		t.Helper()
+		var data string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && data != "" {
+				break
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		}
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if u := readEvent(); u.Index != store.state.Index || !u.TotalSupply.Equals(types.Siacoins(100)) {
+		t.Fatalf("unexpected initial event %+v", u)
+	}
+	next := index.State{
+		Index:       types.ChainIndex{Height: 11, ID: types.BlockID{2}},
+		TotalSupply: types.Siacoins(110),
+	}
+	store.setState(next)
+	if u := readEvent(); u.Index != next.Index || !u.TotalSupply.Equals(types.Siacoins(110)) {
+		t.Fatalf("unexpected event %+v", u)
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	srv := NewServer(&mockStore{}, mockChain{}, WithLogger(zap.New(core)), WithRequestLogLevel(zap.InfoLevel))
+
+	for _, path := range []string{"/supply/total", "/supply/total?units=foo"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %d", len(entries))
+	} else if entries[0].Level != zap.InfoLevel || entries[0].ContextMap()["status"] != int64(http.StatusOK) {
+		t.Fatalf("unexpected entry for successful request: %v %v", entries[0].Level, entries[0].ContextMap())
+	} else if entries[1].Level != zap.WarnLevel || entries[1].ContextMap()["status"] != int64(http.StatusBadRequest) {
+		t.Fatalf("unexpected entry for failed request: %v %v", entries[1].Level, entries[1].ContextMap())
+	} else if entries[0].ContextMap()["path"] != "/supply/total" {
+		t.Fatalf("expected path to be logged, got %v", entries[0].ContextMap())
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	store := &mockStore{history: make(map[uint64]index.State)}
+	for height := uint64(0); height < 5000; height++ {
+		store.history[height] = index.State{
+			Index:       types.ChainIndex{Height: height, ID: frand.Entropy256()},
+			TotalSupply: types.Siacoins(uint32(height)),
+		}
+	}
+	srv := NewServer(store, mockChain{})
+
+	get := func(path string, gzipped bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := get("/supply/history.csv", false)
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatal("expected uncompressed response")
+	}
+
+	compressed := get("/supply/history.csv", true)
+	if compressed.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, compressed.Code)
+	} else if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("expected gzip response")
+	} else if compressed.Body.Len() >= plain.Body.Len() {
+		t.Fatalf("expected compressed body to be smaller, got %d >= %d", compressed.Body.Len(), plain.Body.Len())
+	}
+	r, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf, plain.Body.Bytes()) {
+		t.Fatal("decompressed body does not match uncompressed body")
+	}
+
+	// small responses should not be compressed
+	small := get("/supply/total", true)
+	if small.Header().Get("Content-Encoding") != "" {
+		t.Fatal("expected small response to be uncompressed")
+	} else if small.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, small.Code)
+	}
+
+	// errors should keep their status when compression is requested
+	if rec := get("/supply/total?units=foo", true); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	const origin = "https://dashboard.example.com"
+
+	request := func(srv http.Handler, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/supply/total", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// cross-origin requests are not allowed by default
+	srv := NewServer(&mockStore{}, mockChain{})
+	if rec := request(srv, http.MethodGet, origin); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("expected no CORS headers by default")
+	}
+
+	srv = NewServer(&mockStore{}, mockChain{}, WithCORSOrigins([]string{origin}))
+	if rec := request(srv, http.MethodGet, origin); rec.Header().Get("Access-Control-Allow-Origin") != origin {
+		t.Fatalf("expected allowed origin %q, got %q", origin, rec.Header().Get("Access-Control-Allow-Origin"))
+	} else if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec := request(srv, http.MethodOptions, origin)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	} else if rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Fatal("expected allowed methods in preflight response")
+	}
+
+	if rec := request(srv, http.MethodGet, "https://evil.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("expected no CORS headers for disallowed origin")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	store := &mockStore{
+		state: index.State{
+			Index:       types.ChainIndex{Height: 10, ID: frand.Entropy256()},
+			Timestamp:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			TotalSupply: types.Siacoins(100),
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/supply/total", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if etag != `"`+store.state.Index.ID.String()+`"` {
+		t.Fatalf("unexpected ETag %q", etag)
+	} else if lm := rec.Header().Get("Last-Modified"); lm != "" {
+		t.Fatalf("unexpected Last-Modified %q", lm)
+	}
+
+	if rec := get("If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, got %d", http.StatusNotModified, rec.Code)
+	} else if rec.Body.Len() != 0 {
+		t.Fatal("expected empty body")
+	}
+	// block timestamps are not monotonic, so If-Modified-Since is ignored
+	if rec := get("If-Modified-Since", "Wed, 01 Jan 2025 00:00:00 GMT"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// index a new block with an earlier timestamp
+	store.state.Index = types.ChainIndex{Height: 11, ID: frand.Entropy256()}
+	store.state.Timestamp = store.state.Timestamp.Add(-time.Minute)
+	if rec := get("If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestAdminKey(t *testing.T) {
+	const key = "hunter2"
+	srv := NewServer(&mockStore{}, mockChain{}, WithAdminKey(key), WithProtectedRoutes("/metrics"))
+
+	get := func(path string, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		header, value string
+		status        int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"X-API-Key", "wrong", http.StatusUnauthorized},
+		{"Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"X-API-Key", key, http.StatusOK},
+		{"Authorization", "Bearer " + key, http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := get("/metrics", test.header, test.value)
+		if rec.Code != test.status {
+			t.Fatalf("%s %q: expected status %d, got %d", test.header, test.value, test.status, rec.Code)
+		} else if test.status != http.StatusUnauthorized {
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		} else if resp.Error == "" {
+			t.Fatal("expected error message")
+		}
+	}
+
+	// public routes do not require the key
+	if rec := get("/supply/total", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+type mockReindexer struct {
+	from []uint64
+	err  error
+}
+
+func (mr *mockReindexer) Reindex(_ context.Context, from uint64) error {
+	mr.from = append(mr.from, from)
+	return mr.err
+}
+
+func TestAdminReindex(t *testing.T) {
+	const key = "hunter2"
+	mr := new(mockReindexer)
+	srv := NewServer(&mockStore{}, mockChain{}, WithAdminKey(key), WithReindexer(mr))
+
+	post := func(path string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("/admin/reindex?from=10", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	} else if rec := post("/admin/reindex", key); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	} else if len(mr.from) != 0 {
+		t.Fatalf("expected no reindex, got %v", mr.from)
+	}
+
+	if rec := post("/admin/reindex?from=10", key); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	} else if len(mr.from) != 1 || mr.from[0] != 10 {
+		t.Fatalf("expected reindex from 10, got %v", mr.from)
+	}
+
+	mr.err = index.ErrNotBestChain
+	if rec := post("/admin/reindex?from=10", key); rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+
+	// the route is not registered without a reindexer
+	srv = NewServer(&mockStore{}, mockChain{}, WithAdminKey(key))
+	if rec := post("/admin/reindex?from=10", key); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRichListCursor(t *testing.T) {
+	store := new(mockStore)
+	for i := 5; i > 0; i-- {
+		store.balances = append(store.balances, index.AddressBalance{
+			Address: frand.Entropy256(),
+			Balance: types.Siacoins(uint32(i)),
+		})
+	}
+	srv := NewServer(store, mockChain{})
+
+	get := func(query string) ([]index.AddressBalance, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/addresses/rich?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+		var resp RichListResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Addresses, resp.NextCursor
+	}
+
+	var paged []index.AddressBalance
+	balances, cursor := get("limit=2")
+	paged = append(paged, balances...)
+	for cursor != "" {
+		balances, cursor = get("limit=2&cursor=" + url.QueryEscape(cursor))
+		paged = append(paged, balances...)
+	}
+	if len(paged) != len(store.balances) {
+		t.Fatalf("expected %d balances, got %d", len(store.balances), len(paged))
+	}
+	for i := range paged {
+		if paged[i] != store.balances[i] {
+			t.Fatalf("expected %v at position %d, got %v", store.balances[i], i, paged[i])
+		}
+	}
+
+	for _, query := range []string{"cursor=foo", "cursor=1-foo", "offset=1&cursor=" + url.QueryEscape(RichListCursor{Balance: types.Siacoins(1)}.String())} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/addresses/rich?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestAddressBalance(t *testing.T) {
+	addr := types.Address(frand.Entropy256())
+	store := &mockStore{
+		balances: []index.AddressBalance{{Address: addr, Balance: types.Siacoins(10)}},
+	}
+	srv := NewServer(store, mockChain{}, WithAdminKey("hunter2"), WithProtectedRoutes("/addresses/rich"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/addresses/" + addr.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var resp AddressBalanceResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Address != addr || !resp.Balance.Equals(types.Siacoins(10)) || resp.Foundation {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if rec := get("/addresses/" + types.Address(frand.Entropy256()).String()); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	} else if rec := get("/addresses/foo"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	} else if rec := get("/addresses/addr:" + addr.String()); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d for a prefixed address, got %d", http.StatusOK, rec.Code)
+	}
+
+	// the rich list shares the address route, but protecting it does not
+	// protect address lookups
+	if rec := get("/addresses/rich"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	srv = NewServer(store, mockChain{}, WithAdminKey("hunter2"), WithProtectedRoutes("/addresses/:address"))
+	if rec := get("/addresses/rich"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if rec := get("/addresses/" + addr.String()); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+type mockMaintainer struct {
+	backups []string
+}
+
+func (mm *mockMaintainer) Vacuum() (int64, error) { return 4096, nil }
+
+func (mm *mockMaintainer) Backup(path string) (int64, error) {
+	mm.backups = append(mm.backups, path)
+	return 8192, nil
+}
+
+func TestFoundationAddresses(t *testing.T) {
+	store := &mockStore{
+		treasury: types.Siacoins(150),
+		foundation: []index.AddressBalance{
+			{Address: frand.Entropy256(), Balance: types.Siacoins(100)},
+			{Address: frand.Entropy256(), Balance: types.Siacoins(50)},
+		},
+	}
+	srv := NewServer(store, mockChain{})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/foundation/addresses", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	var balances []index.AddressBalance
+	if err := json.NewDecoder(rec.Body).Decode(&balances); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != len(store.foundation) {
+		t.Fatalf("expected %d addresses, got %d", len(store.foundation), len(balances))
+	}
+	var sum types.Currency
+	for i, b := range balances {
+		if b != store.foundation[i] {
+			t.Fatalf("expected %v, got %v", store.foundation[i], b)
+		}
+		sum = sum.Add(b.Balance)
+	}
+	if !sum.Equals(store.treasury) {
+		t.Fatalf("expected balances to sum to the treasury %v, got %v", store.treasury, sum)
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	const key = "hunter2"
+	mm := new(mockMaintainer)
+	srv := NewServer(&mockStore{}, mockChain{}, WithAdminKey(key), WithMaintainer(mm))
+
+	post := func(path string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/admin/vacuum", "/admin/backup?path=backup.sqlite3"} {
+		if rec := post(path, ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusUnauthorized, rec.Code)
+		}
+	}
+
+	rec := post("/admin/vacuum", key)
+	var resp MaintenanceResponse
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Size != 4096 {
+		t.Fatalf("expected size 4096, got %d", resp.Size)
+	}
+
+	if rec := post("/admin/backup", key); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	rec = post("/admin/backup?path=backup.sqlite3", key)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Size != 8192 || resp.Path != "backup.sqlite3" {
+		t.Fatalf("unexpected response %+v", resp)
+	} else if len(mm.backups) != 1 || mm.backups[0] != "backup.sqlite3" {
+		t.Fatalf("expected one backup, got %v", mm.backups)
+	}
+}
+
+type mockAuditor struct {
+	audit index.Audit
+}
+
+func (ma mockAuditor) Audit() (index.Audit, error) { return ma.audit, nil }
+
+func TestAdminAudit(t *testing.T) {
+	const key = "hunter2"
+	ma := mockAuditor{audit: index.Audit{
+		Index:             types.ChainIndex{Height: 10},
+		CirculatingSupply: types.Siacoins(100),
+		AddressBalances:   types.Siacoins(90),
+	}}
+	srv := NewServer(&mockStore{}, mockChain{}, WithAdminKey(key), WithAuditor(ma))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req.Header.Set("X-API-Key", key)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	var resp AuditResponse
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if resp.Consistent {
+		t.Fatal("expected inconsistent audit")
+	} else if expected := "-" + types.Siacoins(10).ExactString(); resp.Difference != expected {
+		t.Fatalf("expected difference %q, got %q", expected, resp.Difference)
+	} else if !resp.CirculatingSupply.Equals(types.Siacoins(100)) || !resp.AddressBalances.Equals(types.Siacoins(90)) {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestCirculatingExclusions(t *testing.T) {
+	const key = "hunter2"
+	escrow, burn := types.Address(frand.Entropy256()), types.Address(frand.Entropy256())
+	store := &mockStore{
+		state:    index.State{CirculatingSupply: types.Siacoins(100)},
+		treasury: types.Siacoins(10),
+		balances: []index.AddressBalance{
+			{Address: escrow, Balance: types.Siacoins(20)},
+			{Address: burn, Balance: types.Siacoins(5)},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "exclude.txt")
+	writeFile := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// duplicate addresses are only excluded once
+	writeFile("# escrow\n" + escrow.String() + "\n\n")
+	l, err := NewExclusionList([]string{"addr:" + escrow.String()}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(store, mockChain{}, WithAdminKey(key), WithExclusionList(l))
+
+	// checkCirculating returns the ETag of the response. A client that
+	// cached an older response sends its ETag.
+	checkCirculating := func(expected float64, etag string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/supply/circulating", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		var circulating float64
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		} else if err := json.NewDecoder(rec.Body).Decode(&circulating); err != nil {
+			t.Fatal(err)
+		} else if circulating != expected {
+			t.Fatalf("expected circulating supply %v, got %v", expected, circulating)
+		}
+		return rec.Header().Get("ETag")
+	}
+	reload := func() *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/exclusions/reload", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	// every response that reports the circulating supply agrees
+	checkEndpoints := func(expected float64) {
+		t.Helper()
+		for _, path := range []string{"/supply", "/cmc/supply", "/coingecko/supply"} {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			var resp struct {
+				CirculatingSupply float64 `json:"circulating_supply"` //nolint:tagliatelle
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rec.Code)
+			} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			} else if resp.CirculatingSupply != expected {
+				t.Fatalf("%s: expected circulating supply %v, got %v", path, expected, resp.CirculatingSupply)
+			}
+		}
+
+		hastings := types.Siacoins(uint32(expected)).ExactString()
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if line := "sia_circulating_supply_hastings " + hastings; !strings.Contains(rec.Body.String(), line) {
+			t.Fatalf("expected metrics to contain %q, got %q", line, rec.Body.String())
+		}
+
+		hub := &supplyHub{store: store, exclusions: l}
+		if u, err := hub.current(); err != nil {
+			t.Fatal(err)
+		} else if u.CirculatingSupply.ExactString() != hastings {
+			t.Fatalf("expected streamed circulating supply %v, got %v", hastings, u.CirculatingSupply.ExactString())
+		}
+	}
+	etag := checkCirculating(70, "")
+	checkEndpoints(70)
+
+	// the file is read again on reload
+	writeFile(escrow.String() + "\n" + burn.String() + "\n")
+	rec := reload()
+	var resp ExclusionListResponse
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	} else if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	} else if len(resp.Addresses) != 2 {
+		t.Fatalf("expected 2 addresses, got %v", resp.Addresses)
+	}
+	// the cached response is stale even though no block was indexed
+	etag = checkCirculating(65, etag)
+	checkEndpoints(65)
+
+	// an invalid file keeps the current list
+	writeFile("foo\n")
+	if rec := reload(); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	} else if !strings.Contains(rec.Body.String(), "exclude.txt:1: address must be 76 hex characters") {
+		t.Fatalf("expected the invalid line to be reported, got %q", rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/supply/circulating", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	addr := types.Address(frand.Entropy256())
+	encoded := addr.String()
+
+	// flip a character of the address so only the checksum is wrong
+	badChecksum := []byte(encoded)
+	if badChecksum[0] == '0' {
+		badChecksum[0] = '1'
+	} else {
+		badChecksum[0] = '0'
+	}
+
+	tests := []struct {
+		s   string
+		err string
+	}{
+		{encoded, ""},
+		{"addr:" + encoded, ""},
+		{encoded[:len(encoded)-2], "address must be 76 hex characters, got 74"},
+		{string(badChecksum), "address has an invalid checksum, check that it was copied correctly"},
+		{strings.Repeat("z", 76), "address must be hex encoded"},
+	}
+	for _, test := range tests {
+		parsed, err := parseAddress(test.s)
+		if test.err == "" {
+			if err != nil {
+				t.Fatalf("%q: %v", test.s, err)
+			} else if parsed != addr {
+				t.Fatalf("%q: expected %v, got %v", test.s, addr, parsed)
+			}
+		} else if err == nil || err.Error() != test.err {
+			t.Fatalf("%q: expected error %q, got %v", test.s, test.err, err)
+		}
+	}
+}
+
+func TestResponseKeys(t *testing.T) {
+	// every response uses the snake_case keys of the CoinMarketCap and
+	// CoinGecko responses, so a value has the same key on every endpoint
+	responses := []any{
+		SupplyResponse{}, CoinGeckoSupplyResponse{}, CMCSupplyResponse{}, ValueResponse{},
+		VersionResponse{}, TipResponse{}, SyncProgressResponse{}, TxpoolSupplyResponse{},
+		SupplyUpdate{}, ErrorResponse{}, HealthResponse{}, SupplyHistoryResponse{},
+		BurnedSupplyPoint{}, BurnedHistoryResponse{}, EmissionResponse{}, InflationResponse{},
+		AddressBalanceResponse{}, RichListResponse{}, MaintenanceResponse{}, AuditResponse{}, ExclusionListResponse{},
+	}
+	for _, resp := range responses {
+		rt := reflect.TypeOf(resp)
+		for i := 0; i < rt.NumField(); i++ {
+			key, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+			if key != strings.ToLower(key) {
+				t.Errorf("%s.%s has key %q, expected snake_case", rt.Name(), rt.Field(i).Name, key)
+			}
+		}
+	}
+}
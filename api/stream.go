@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/jape"
+	"go.uber.org/zap"
+)
+
+const (
+	// supplyPollInterval is the interval the supply hub checks the store for
+	// a new indexed state while there are subscribers, if the server does not
+	// have a Notifier.
+	supplyPollInterval = time.Second
+
+	// streamWriteTimeout is the maximum time a streamed update can take to
+	// write before the client is disconnected.
+	streamWriteTimeout = 15 * time.Second
+
+	// sseHeartbeatInterval is the interval between heartbeat comments sent
+	// on idle server-sent event streams.
+	sseHeartbeatInterval = 30 * time.Second
+)
+
+// A Notifier notifies the server's supply streams each time a new state is
+// indexed, so the store does not need to be polled.
+type Notifier struct {
+	c chan struct{}
+}
+
+// Notify signals that state was committed. It never blocks, so it can be
+// called from the indexer, e.g. with [index.WithOnStateUpdate].
+func (n *Notifier) Notify(state index.State) {
+	select {
+	case n.c <- struct{}{}:
+	default:
+		// a notification is already pending
+	}
+}
+
+// NewNotifier returns a new Notifier.
+func NewNotifier() *Notifier {
+	return &Notifier{c: make(chan struct{}, 1)}
+}
+
+// A supplyHub broadcasts the indexed supply to subscribers each time the index
+// changes. A single goroutine watches for changes while there are
+// subscribers, so the number of connected clients does not affect the load on
+// the store.
+type supplyHub struct {
+	store      Store
+	exclusions *ExclusionList
+	log        *zap.Logger
+	// notifier, if set, signals new states instead of polling the store
+	notifier *Notifier
+
+	mu     sync.Mutex
+	last   SupplyUpdate
+	subs   map[chan SupplyUpdate]struct{}
+	cancel context.CancelFunc
+}
+
+// current returns the current indexed supply.
+func (h *supplyHub) current() (SupplyUpdate, error) {
+	state, circulating, _, err := circulatingSupply(h.store, h.exclusions)
+	if err != nil {
+		return SupplyUpdate{}, err
+	}
+	return SupplyUpdate{
+		Index:             state.Index,
+		Timestamp:         state.Timestamp,
+		TotalSupply:       state.TotalSupply,
+		CirculatingSupply: circulating,
+		BurnedSupply:      state.BurnedSupply,
+	}, nil
+}
+
+// broadcast sends u to every subscriber. Subscribers only need the latest
+// supply, so a slow subscriber's pending update is replaced rather than
+// blocking the others.
+func (h *supplyHub) broadcast(u SupplyUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if u.Index == h.last.Index {
+		return
+	}
+	h.last = u
+	for ch := range h.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u
+	}
+}
+
+// watch broadcasts the indexed supply whenever it changes until ctx is
+// canceled.
+func (h *supplyHub) watch(ctx context.Context) {
+	var poll <-chan time.Time
+	var notify <-chan struct{}
+	if h.notifier != nil {
+		notify = h.notifier.c
+	} else {
+		t := time.NewTicker(supplyPollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll:
+		case <-notify:
+		}
+
+		u, err := h.current()
+		if err != nil {
+			h.log.Warn("failed to get supply", zap.Error(err))
+			continue
+		}
+		h.broadcast(u)
+	}
+}
+
+// subscribe returns a channel that receives the current supply, followed by
+// the supply each time the index changes. The returned function must be
+// called to unsubscribe.
+func (h *supplyHub) subscribe() (<-chan SupplyUpdate, func(), error) {
+	u, err := h.current()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan SupplyUpdate, 1)
+	ch <- u
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[chan SupplyUpdate]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		go h.watch(ctx)
+	}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs, ch)
+		if len(h.subs) == 0 && h.cancel != nil {
+			h.cancel()
+			h.cancel = nil
+		}
+	}, nil
+}
+
+// hijackWriter exposes the http.Hijacker of a wrapped http.ResponseWriter.
+// The websocket package requires the ResponseWriter to implement it directly,
+// but the middleware wrappers only support http.ResponseController.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// websocketOptions returns the accept options for websocket connections. The
+// allowed origins are the same as for cross-origin requests.
+func (s *server) websocketOptions() *websocket.AcceptOptions {
+	opts := new(websocket.AcceptOptions)
+	for _, origin := range s.corsOrigins {
+		if origin == "*" {
+			opts.InsecureSkipVerify = true
+			break
+		} else if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			opts.OriginPatterns = append(opts.OriginPatterns, u.Host)
+		}
+	}
+	return opts
+}
+
+// handleGETWSSupply upgrades the connection to a websocket and sends the
+// current supply, followed by the supply each time a new block is indexed.
+func (s *server) handleGETWSSupply(jc jape.Context) {
+	updates, unsubscribe, err := s.hub.subscribe()
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	}
+	defer unsubscribe()
+
+	// the connection outlives the server's read and write timeouts
+	rc := http.NewResponseController(jc.ResponseWriter)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(hijackWriter{jc.ResponseWriter}, jc.Request, s.websocketOptions())
+	if err != nil {
+		s.log.Debug("failed to accept websocket", zap.Error(err))
+		return // the error response has already been written
+	}
+	defer conn.CloseNow()
+
+	// clients only receive updates, CloseRead handles control frames and
+	// cancels ctx when the client disconnects. ctx is not derived from the
+	// request so the client's reply to a close frame can still be read.
+	ctx := conn.CloseRead(context.Background())
+	// http.Server.Shutdown does not wait for hijacked connections. The
+	// request context is derived from the server's base context, so it is
+	// canceled when the server shuts down.
+	shutdown := jc.Request.Context().Done()
+	var sent *SupplyUpdate
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdown:
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		case u := <-updates:
+			if sent != nil && u.Index == sent.Index {
+				// the hub may broadcast the state sent on connect
+				continue
+			}
+			sent = &u
+			writeCtx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+			err := wsjson.Write(writeCtx, conn, u)
+			cancel()
+			if err != nil {
+				s.log.Debug("failed to write supply update", zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+// handleGETSSESupply streams the current supply, followed by the supply each
+// time a new block is indexed, as server-sent events. Heartbeat comments are
+// sent between updates so proxies do not close idle connections.
+func (s *server) handleGETSSESupply(jc jape.Context) {
+	updates, unsubscribe, err := s.hub.subscribe()
+	if jc.Check("failed to get supply", err) != nil {
+		return
+	}
+	defer unsubscribe()
+
+	w := jc.ResponseWriter
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	rc := http.NewResponseController(w)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	var sent *SupplyUpdate
+	for {
+		var event []byte
+		select {
+		case <-jc.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			event = []byte(": heartbeat\n\n")
+		case u := <-updates:
+			if sent != nil && u.Index == sent.Index {
+				// the hub may broadcast the state sent on connect
+				continue
+			}
+			sent = &u
+			data, err := json.Marshal(u)
+			if err != nil {
+				panic(err) // should never happen
+			}
+			event = fmt.Appendf(nil, "event: supply\nid: %d\ndata: %s\n\n", u.Index.Height, data)
+		}
+
+		// the stream outlives the server's write timeout, extend the
+		// deadline for each event instead
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := w.Write(event); err != nil {
+			return
+		} else if err := rc.Flush(); err != nil {
+			s.log.Debug("failed to flush supply event", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package build reports information about the running build.
+package build
+
+import (
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// commit and buildTime can be set at link time, e.g.
+//
+//	go build -ldflags "-X go.sia.tech/cmc-supply-api/build.commit=$(git rev-parse HEAD) -X go.sia.tech/cmc-supply-api/build.buildTime=$(date +%s)"
+//
+// If they are not set, the VCS information embedded by the Go toolchain is
+// used instead.
+var (
+	commit    string
+	buildTime string
+)
+
+// Version returns the module version of the build.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// Commit returns the git commit of the build.
+func Commit() string {
+	if commit != "" {
+		return commit
+	}
+	return vcsSetting("vcs.revision")
+}
+
+// Time returns the time of the build. For builds without a linker-injected
+// build time, the time of the commit is returned.
+func Time() time.Time {
+	if buildTime != "" {
+		if unix, err := strconv.ParseInt(buildTime, 10, 64); err == nil {
+			return time.Unix(unix, 0).UTC()
+		}
+	}
+	t, _ := time.Parse(time.RFC3339, vcsSetting("vcs.time"))
+	return t
+}
+
+// vcsSetting returns the value of the VCS build setting with the given key.
+func vcsSetting(key string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
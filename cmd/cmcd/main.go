@@ -7,20 +7,176 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/shopspring/decimal"
+	"go.sia.tech/cmc-supply-api/api"
 	"go.sia.tech/cmc-supply-api/index"
 	"go.sia.tech/cmc-supply-api/persist/sqlite"
-	"go.sia.tech/jape"
-	"go.sia.tech/walletd/api"
+	wapi "go.sia.tech/walletd/api"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// walletdPasswordEnvVar is the environment variable the walletd API password
+// can be read from.
+const walletdPasswordEnvVar = "WALLETD_API_PASSWORD"
+
+const (
+	// minConnectRetryInterval and maxConnectRetryInterval bound the backoff
+	// between attempts to connect to walletd at startup.
+	minConnectRetryInterval = time.Second
+	maxConnectRetryInterval = 15 * time.Second
+)
+
+// shutdownTimeout is the maximum time in-flight requests are given to complete
+// when the server is shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// httpTimeouts are the connection timeouts of the API server. Routes that
+// stream large responses, such as the CSV export and the supply streams,
+// extend their write deadline as they write.
+type httpTimeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+var defaultHTTPTimeouts = httpTimeouts{
+	Read:       15 * time.Second,
+	ReadHeader: 5 * time.Second,
+	Write:      15 * time.Second,
+	Idle:       2 * time.Minute,
+}
+
+// serveHTTP serves h on l until ctx is canceled. The server is then shut down,
+// giving in-flight requests up to timeout to complete. Request contexts are
+// derived from ctx so streaming responses end when shutdown begins.
+func serveHTTP(ctx context.Context, l net.Listener, h http.Handler, timeouts httpTimeouts, timeout time.Duration) error {
+	s := &http.Server{
+		ReadTimeout:       timeouts.Read,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		Handler:           h,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve(l)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		s.Close()
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	return nil
+}
+
+// pprofHandler returns a handler serving the net/http/pprof profiles under
+// /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// checkLoopbackAddr returns an error if addr does not have a loopback host.
+// The profiling endpoints expose internal details of the process and must
+// not be reachable from other machines.
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	} else if host == "localhost" {
+		return nil
+	} else if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("host %q is not a loopback address", host)
+	}
+	return nil
+}
+
+// waitForWalletd calls check until it succeeds, backing off between attempts.
+// walletd may still be starting when cmcd starts, e.g. in docker compose, so
+// an error is only returned once timeout has passed or ctx is canceled.
+func waitForWalletd(ctx context.Context, check func() error, timeout time.Duration, log *zap.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := minConnectRetryInterval
+	for attempt := 1; ; attempt++ {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		default:
+		}
+		log.Warn("failed to connect to walletd", zap.Int("attempt", attempt), zap.Duration("retry", interval), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxConnectRetryInterval)
+	}
+}
+
+// walletdPassword returns the walletd API password from the -password flag,
+// the password file, or the environment variable. At most one can be set, so
+// a stale source can't silently override another. The password file's
+// trailing newline is removed.
+func walletdPassword(password, passwordFile, env string) (string, error) {
+	var sources []string
+	if password != "" {
+		sources = append(sources, "-password")
+	}
+	if passwordFile != "" {
+		sources = append(sources, "-password-file")
+	}
+	if env != "" {
+		sources = append(sources, walletdPasswordEnvVar)
+	}
+	if len(sources) > 1 {
+		return "", fmt.Errorf("password is set by %s, only one can be used", strings.Join(sources, " and "))
+	}
+
+	switch {
+	case passwordFile != "":
+		buf, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	case env != "":
+		return env, nil
+	default:
+		return password, nil
+	}
+}
+
 func checkFatalError(context string, err error) {
 	if err != nil {
 		os.Stderr.WriteString(fmt.Sprintf("%s: %v\n", context, err))
@@ -31,14 +187,81 @@ func checkFatalError(context string, err error) {
 func main() {
 	var (
 		dir                = "."
+		dbPath             string
+		httpAddr           = ":8080"
 		walletdAPIAddr     = "http://localhost:9980/api"
 		walletdAPIPassword = ""
+		passwordFile       string
+		connectTimeout     = time.Minute
+		timeouts           = defaultHTTPTimeouts
 		logLevel           = "info"
+		requestLogLevel    = "debug"
+		maxHealthLag       = uint64(6)
+		stallThreshold     = index.DefaultStallThreshold
+		batchSize          = index.DefaultBatchSize
+		pollInterval       = index.DefaultPollInterval
+		requestTimeout     = index.DefaultRequestTimeout
+		maxSupply          float64
+		maxRangeSpan       uint64
+		maxExportSpan      uint64
+		corsOrigins        string
+		adminKey           string
+		adminRoutes        string
+		retain             uint64
+		dbBusyTimeout      time.Duration
+		pprofAddr          string
+		network            = "mainnet"
+		logStdout          = true
+		logFile            string
+		logMaxSize         = 100
+		logMaxAge          int
+		logFormat          = "console"
+		dryRun             bool
+		reset              bool
+		resetMainnet       bool
+		excludeAddrs       string
+		excludeFile        string
+		exactDecimals      bool
 	)
 	flag.StringVar(&dir, "dir", dir, "Directory to store the supply data")
+	flag.StringVar(&dbPath, "db", dbPath, "Path of the supply database, or :memory: to keep the index in memory. Defaults to supply.sqlite3 in -dir")
+	flag.StringVar(&httpAddr, "http", httpAddr, "Address to serve the supply API on")
+	flag.DurationVar(&timeouts.Read, "http.readTimeout", timeouts.Read, "Maximum time to read a request, including the body")
+	flag.DurationVar(&timeouts.ReadHeader, "http.readHeaderTimeout", timeouts.ReadHeader, "Maximum time to read a request's headers")
+	flag.DurationVar(&timeouts.Write, "http.writeTimeout", timeouts.Write, "Maximum time to write a response. The CSV export and supply streams extend it as they write")
+	flag.DurationVar(&timeouts.Idle, "http.idleTimeout", timeouts.Idle, "Maximum time to keep an idle connection open between requests")
+	flag.Uint64Var(&maxRangeSpan, "http.maxSpan", maxRangeSpan, "Maximum number of blocks a history range query can span. Unlimited if zero")
+	flag.Uint64Var(&maxExportSpan, "http.maxExportSpan", maxExportSpan, "Maximum number of blocks a CSV history export can span. Unlimited if zero")
 	flag.StringVar(&walletdAPIAddr, "api", walletdAPIAddr, "Walletd API address")
-	flag.StringVar(&walletdAPIPassword, "password", walletdAPIPassword, "Walletd API password")
+	flag.StringVar(&walletdAPIPassword, "password", walletdAPIPassword, fmt.Sprintf("Walletd API password. Prefer -password-file or %s, which are not visible in process listings", walletdPasswordEnvVar))
+	flag.StringVar(&passwordFile, "password-file", passwordFile, "File containing the walletd API password")
+	flag.DurationVar(&connectTimeout, "api.wait", connectTimeout, "How long to retry connecting to walletd at startup before giving up")
+	flag.DurationVar(&requestTimeout, "api.timeout", requestTimeout, "Maximum time to wait for a response from walletd before the request is retried")
+	flag.StringVar(&network, "network", network, "Network walletd is expected to be running on (mainnet, zen, or anagami)")
 	flag.StringVar(&logLevel, "log", logLevel, "Log level")
+	flag.StringVar(&logFormat, "log.format", logFormat, "Log format (console or json)")
+	flag.BoolVar(&logStdout, "log.stdout", logStdout, "Write logs to stdout")
+	flag.StringVar(&logFile, "log.file", logFile, "File to write logs to. Disabled if empty")
+	flag.IntVar(&logMaxSize, "log.maxSize", logMaxSize, "Size in megabytes the log file can reach before it is rotated")
+	flag.IntVar(&logMaxAge, "log.maxAge", logMaxAge, "Number of days to keep rotated log files. If zero, they are kept indefinitely")
+	flag.StringVar(&requestLogLevel, "log.requests", requestLogLevel, "Log level for successful API requests")
+	flag.IntVar(&batchSize, "batch", batchSize, "Number of blocks to request from walletd at a time")
+	flag.BoolVar(&dryRun, "index.dryRun", dryRun, "Index without committing updates to the database, logging the computed state of each block instead")
+	flag.BoolVar(&reset, "reset", reset, "Clear the index at startup and index the chain again from genesis")
+	flag.BoolVar(&resetMainnet, "reset.mainnet", resetMainnet, "Confirm -reset on mainnet, where indexing again takes several hours")
+	flag.DurationVar(&pollInterval, "poll", pollInterval, "Interval to check walletd for new blocks once synced")
+	flag.Uint64Var(&maxHealthLag, "health.lag", maxHealthLag, "Maximum number of blocks the index can be behind the chain tip before it is reported as unhealthy")
+	flag.DurationVar(&stallThreshold, "health.stall", stallThreshold, "Maximum time without a new indexed block before the chain is reported as stalled. Disabled if zero")
+	flag.Float64Var(&maxSupply, "supply.max", maxSupply, "Maximum supply in siacoins to report. If zero, the maximum supply is reported as null")
+	flag.BoolVar(&exactDecimals, "supply.exact", exactDecimals, "Encode siacoin values as exact decimal strings instead of float64 numbers by default. Clients can override it with the exact query parameter")
+	flag.StringVar(&excludeAddrs, "circulating.exclude", excludeAddrs, "Comma-separated list of addresses to exclude from the circulating supply, in addition to the foundation treasury")
+	flag.StringVar(&excludeFile, "circulating.excludeFile", excludeFile, "File containing addresses to exclude from the circulating supply, one per line. It is read again by [POST] /admin/exclusions/reload")
+	flag.StringVar(&corsOrigins, "cors.origins", corsOrigins, "Comma-separated list of origins allowed to make cross-origin requests, or * to allow all origins")
+	flag.StringVar(&adminKey, "admin-key", adminKey, "Key required to access admin routes")
+	flag.StringVar(&adminRoutes, "admin.routes", adminRoutes, "Comma-separated list of additional route paths that require the admin key, e.g. /metrics")
+	flag.Uint64Var(&retain, "retain", retain, fmt.Sprintf("Number of blocks of supply history to keep. Must be zero, to keep all history, or at least %d", sqlite.MinHistoryRetention))
+	flag.DurationVar(&dbBusyTimeout, "db.timeout", dbBusyTimeout, "How long to wait for a database lock before failing. If zero, the default of 10s is used")
+	flag.StringVar(&pprofAddr, "pprof", pprofAddr, "Localhost address to serve pprof profiles on, e.g. localhost:6060. Disabled if empty")
 	flag.Parse()
 
 	cfg := zap.NewProductionEncoderConfig()
@@ -49,7 +272,26 @@ func main() {
 
 	cfg.StacktraceKey = ""
 	cfg.CallerKey = ""
-	encoder := zapcore.NewConsoleEncoder(cfg)
+
+	// the file has no color codes and includes timestamps since it is not
+	// read through a terminal or journal
+	fileCfg := cfg
+	fileCfg.TimeKey = "ts"
+	fileCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	var stdoutEncoder, fileEncoder zapcore.Encoder
+	switch logFormat {
+	case "console":
+		stdoutEncoder = zapcore.NewConsoleEncoder(cfg)
+		fileEncoder = zapcore.NewConsoleEncoder(fileCfg)
+	case "json":
+		// structured logs are ingested by other tools, which need the
+		// timestamp and an uncolored level
+		stdoutEncoder = zapcore.NewJSONEncoder(fileCfg)
+		fileEncoder = zapcore.NewJSONEncoder(fileCfg)
+	default:
+		checkFatalError("invalid log format", fmt.Errorf("unknown format %q", logFormat))
+	}
 
 	var level zap.AtomicLevel
 	switch logLevel {
@@ -66,90 +308,231 @@ func main() {
 		os.Exit(1)
 	}
 
-	log := zap.New(zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level))
+	if !logStdout && logFile == "" {
+		checkFatalError("invalid log output", errors.New("-log.file must be set if -log.stdout is disabled"))
+	} else if logMaxSize <= 0 {
+		checkFatalError("invalid log size", errors.New("must be positive"))
+	} else if logMaxAge < 0 {
+		checkFatalError("invalid log age", errors.New("must not be negative"))
+	}
+
+	var cores []zapcore.Core
+	if logStdout {
+		cores = append(cores, zapcore.NewCore(stdoutEncoder, zapcore.Lock(os.Stdout), level))
+	}
+	if logFile != "" {
+		lw := &lumberjack.Logger{
+			Filename: logFile,
+			MaxSize:  logMaxSize,
+			MaxAge:   logMaxAge,
+		}
+		defer lw.Close()
+		cores = append(cores, zapcore.NewCore(fileEncoder, zapcore.AddSync(lw), level))
+	}
+
+	log := zap.New(zapcore.NewTee(cores...))
 	defer log.Sync()
 
 	zap.RedirectStdLog(log)
 
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		log.Fatal("failed to create data directory", zap.String("dir", dir), zap.Error(err))
+	requestLevel, err := zapcore.ParseLevel(requestLogLevel)
+	checkFatalError("invalid request log level", err)
+
+	walletdAPIPassword, err = walletdPassword(walletdAPIPassword, passwordFile, os.Getenv(walletdPasswordEnvVar))
+	checkFatalError("invalid walletd password", err)
+
+	switch network {
+	case "mainnet", "zen", "anagami":
+	default:
+		checkFatalError("invalid network", fmt.Errorf("unknown network %q", network))
+	}
+
+	if batchSize <= 0 || batchSize > index.MaxBatchSize {
+		checkFatalError("invalid batch size", fmt.Errorf("must be between 1 and %d", index.MaxBatchSize))
+	} else if pollInterval <= 0 {
+		checkFatalError("invalid poll interval", errors.New("must be positive"))
+	} else if requestTimeout <= 0 {
+		checkFatalError("invalid walletd timeout", errors.New("must be positive"))
+	} else if maxSupply < 0 {
+		checkFatalError("invalid max supply", errors.New("must not be negative"))
+	} else if retain > 0 && retain < sqlite.MinHistoryRetention {
+		checkFatalError("invalid retention", fmt.Errorf("must be zero or at least %d", sqlite.MinHistoryRetention))
+	} else if adminRoutes != "" && adminKey == "" {
+		checkFatalError("invalid admin routes", errors.New("-admin-key must be set to protect routes"))
+	} else if timeouts.Read <= 0 || timeouts.ReadHeader <= 0 || timeouts.Write <= 0 || timeouts.Idle <= 0 {
+		checkFatalError("invalid http timeouts", errors.New("must be positive"))
+	} else if connectTimeout < 0 {
+		checkFatalError("invalid walletd wait", errors.New("must not be negative"))
+	} else if reset && dryRun {
+		checkFatalError("invalid reset", errors.New("-reset cannot be used with -index.dryRun"))
+	} else if reset && network == "mainnet" && !resetMainnet {
+		checkFatalError("refusing to reset the mainnet index", errors.New("-reset.mainnet must also be set"))
+	} else if stallThreshold < 0 {
+		checkFatalError("invalid stall threshold", errors.New("must not be negative"))
+	} else if pprofAddr != "" {
+		checkFatalError("invalid pprof address", checkLoopbackAddr(pprofAddr))
 	}
 
-	db, err := sqlite.OpenDatabase(filepath.Join(dir, "supply.sqlite3"), log.Named("sqlite3"))
+	var exclusions *api.ExclusionList
+	if excludeAddrs != "" || excludeFile != "" {
+		var addrs []string
+		if excludeAddrs != "" {
+			addrs = strings.Split(excludeAddrs, ",")
+		}
+		exclusions, err = api.NewExclusionList(addrs, excludeFile)
+		checkFatalError("invalid circulating supply exclusions", err)
+	}
+
+	if dbPath == "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatal("failed to create data directory", zap.String("dir", dir), zap.Error(err))
+		}
+		dbPath = filepath.Join(dir, "supply.sqlite3")
+	} else if dbPath == sqlite.MemoryDatabase {
+		log.Warn("using an in-memory database, the index will be lost on shutdown")
+	}
+
+	dbOpts := []sqlite.Option{
+		sqlite.WithHistoryRetention(retain),
+	}
+	if dbBusyTimeout > 0 {
+		dbOpts = append(dbOpts, sqlite.WithBusyTimeout(dbBusyTimeout))
+	}
+	db, err := sqlite.OpenDatabase(dbPath, log.Named("sqlite3"), dbOpts...)
 	checkFatalError("failed to open database", err)
 	defer db.Close()
 
-	wc := api.NewClient(walletdAPIAddr, walletdAPIPassword)
-	_, err = wc.ConsensusTip()
-	checkFatalError("failed to validate walletd credentials", err)
+	// a mismatch means an update was applied incorrectly. The index can
+	// still be served, so only warn.
+	if audit, err := db.Audit(); err != nil {
+		log.Warn("failed to audit index", zap.Error(err))
+	} else if !audit.Consistent() {
+		log.Warn("address balances do not match the circulating supply", zap.Stringer("index", audit.Index), zap.Stringer("circulating", audit.CirculatingSupply), zap.Stringer("balances", audit.AddressBalances))
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	wc := wapi.NewClient(walletdAPIAddr, walletdAPIPassword)
+	err = waitForWalletd(ctx, func() error {
+		_, err := wc.ConsensusTip()
+		return err
+	}, connectTimeout, log)
+	checkFatalError("failed to validate walletd credentials", err)
+	// the supply is derived from the consensus state reported by walletd, so
+	// it is only correct for the network walletd is running on
+	n, err := wc.ConsensusNetwork()
+	checkFatalError("failed to get walletd network", err)
+	if n.Name != network {
+		checkFatalError("network mismatch", fmt.Errorf("walletd is running on %q, expected %q", n.Name, network))
+	}
+
+	// the reset waits until walletd's network is checked, so -network can't
+	// be used to skip the mainnet confirmation
+	if reset {
+		checkFatalError("failed to reset index", db.Reset())
+		log.Warn("index reset, the chain will be indexed again from genesis", zap.String("network", network))
+	}
+
+	if pprofAddr != "" {
+		pl, err := net.Listen("tcp", pprofAddr)
+		checkFatalError(fmt.Sprintf("failed to listen on %q", pprofAddr), err)
+		defer pl.Close()
+
+		// no write timeout, CPU profiles and traces stream for their
+		// requested duration
+		ps := &http.Server{Handler: pprofHandler(), ReadHeaderTimeout: 15 * time.Second}
+		go func() {
+			<-ctx.Done()
+			ps.Close()
+		}()
+		go func() {
+			if err := ps.Serve(pl); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("failed to serve pprof", zap.Error(err))
+			}
+		}()
+		log.Info("serving pprof", zap.Stringer("address", pl.Addr()))
+	}
+
+	reindexer := index.NewReindexer()
+	notifier := api.NewNotifier()
+	reorgs := index.NewReorgCounter()
+	indexOpts := []index.Option{
+		index.WithBatchSize(batchSize),
+		index.WithPollInterval(pollInterval),
+		index.WithRequestTimeout(requestTimeout),
+		index.WithReindexer(reindexer),
+		index.WithOnStateUpdate(notifier.Notify),
+		index.WithDryRun(dryRun),
+		index.WithReorgCounter(reorgs),
+	}
+	var stalls *index.StallMonitor
+	if stallThreshold > 0 {
+		stalls = index.NewStallMonitor(stallThreshold)
+		indexOpts = append(indexOpts, index.WithStallMonitor(stalls))
+	}
+	indexerDone := make(chan struct{})
 	go func() {
-		if err := index.UpdateConsensusState(ctx, db, wc, log.Named("index")); err != nil {
+		defer close(indexerDone)
+		if err := index.UpdateConsensusState(ctx, db, wc, log.Named("index"), indexOpts...); err != nil {
 			if !errors.Is(err, context.Canceled) {
 				log.Fatal("failed to index updates", zap.Error(err))
 			}
 		}
 	}()
 
-	l, err := net.Listen("tcp", ":8080")
-	checkFatalError("failed to listen on :8080", err)
+	l, err := net.Listen("tcp", httpAddr)
+	checkFatalError(fmt.Sprintf("failed to listen on %q", httpAddr), err)
 	defer l.Close()
 
-	s := &http.Server{
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		Handler: jape.Mux(map[string]jape.Handler{
-			"GET /tip": func(jc jape.Context) {
-				state, err := db.State()
-				if jc.Check("failed to get state", err) != nil {
-					return
-				}
-				jc.Encode(state.Index)
-			},
-			"GET /supply/total": func(jc jape.Context) {
-				state, err := db.State()
-				if jc.Check("failed to get state", err) != nil {
-					return
-				}
-				jc.Encode(decimal.NewFromBigInt(state.TotalSupply.Big(), -24).InexactFloat64()) // 1 SC = 10^24 H
-			},
-			"GET /supply/circulating": func(jc jape.Context) {
-				foundationTreasury, err := db.FoundationTreasury()
-				if jc.Check("failed to get foundation treasury", err) != nil {
-					return
-				}
-				state, err := db.State()
-				if jc.Check("failed to get state", err) != nil {
-					return
-				}
-				jc.Encode(decimal.NewFromBigInt(state.CirculatingSupply.Sub(foundationTreasury).Big(), -24).InexactFloat64()) // 1 SC = 10^24 H
-			},
-			"GET /supply/burned": func(jc jape.Context) {
-				state, err := db.State()
-				if jc.Check("failed to get state", err) != nil {
-					return
-				}
-				jc.Encode(state.BurnedSupply)
-			},
-			"GET /foundation/treasury": func(jc jape.Context) {
-				foundationTreasury, err := db.FoundationTreasury()
-				if jc.Check("failed to get foundation treasury", err) != nil {
-					return
-				}
-				jc.Encode(decimal.NewFromBigInt(foundationTreasury.Big(), -24).InexactFloat64()) // 1 SC = 10^24 H
-			},
-		}),
-	}
-	defer s.Close()
-
-	go func() {
-		if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal("failed to serve HTTP", zap.Error(err))
-		}
-	}()
+	serverOpts := []api.ServerOption{
+		api.WithMaxHealthLag(maxHealthLag),
+		api.WithLogger(log.Named("api")),
+		api.WithWalletdAddress(walletdAPIAddr),
+		api.WithRequestLogLevel(requestLevel),
+		api.WithReindexer(reindexer),
+		api.WithMaintainer(db),
+		api.WithAuditor(db),
+		api.WithTxpool(wc),
+		api.WithNotifier(notifier),
+		api.WithExactDecimals(exactDecimals),
+		api.WithMaxRangeSpan(maxRangeSpan),
+		api.WithMaxExportSpan(maxExportSpan),
+	}
+	if adminKey != "" {
+		serverOpts = append(serverOpts, api.WithAdminKey(adminKey))
+	}
+	if adminRoutes != "" {
+		serverOpts = append(serverOpts, api.WithProtectedRoutes(strings.Split(adminRoutes, ",")...))
+	}
+	if corsOrigins != "" {
+		serverOpts = append(serverOpts, api.WithCORSOrigins(strings.Split(corsOrigins, ",")))
+	}
+	if maxSupply > 0 {
+		serverOpts = append(serverOpts, api.WithMaxSupply(maxSupply))
+	}
+	if exclusions != nil {
+		serverOpts = append(serverOpts, api.WithExclusionList(exclusions))
+	}
+	if stalls != nil {
+		serverOpts = append(serverOpts, api.WithStallMonitor(stalls))
+	}
+	serverOpts = append(serverOpts, api.WithReorgCounter(reorgs))
+	if err := serveHTTP(ctx, l, api.NewServer(db, wc, serverOpts...), timeouts, shutdownTimeout); err != nil {
+		log.Fatal("failed to serve HTTP", zap.Error(err))
+	}
 
-	<-ctx.Done()
+	// wait for the indexer to finish its current batch so the database is
+	// not closed while it is being written to
+	log.Info("shutting down")
+	select {
+	case <-indexerDone:
+	case <-time.After(shutdownTimeout):
+		log.Fatal("timed out waiting for indexer to stop")
+	}
+	if state, err := db.State(); err != nil {
+		log.Warn("failed to get last indexed state", zap.Error(err))
+	} else {
+		log.Info("shutdown complete", zap.Stringer("index", state.Index))
+	}
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func TestServeHTTPShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveHTTP(ctx, l, h, defaultHTTPTimeouts, 5*time.Second)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		buf, err := io.ReadAll(resp.Body)
+		respCh <- result{body: string(buf), err: err}
+	}()
+
+	// shut down while the request is in flight
+	<-started
+	cancel()
+
+	if res := <-respCh; res.err != nil {
+		t.Fatal(res.err)
+	} else if res.body != "done" {
+		t.Fatalf("expected body %q, got %q", "done", res.body)
+	} else if err := <-serveErr; err != nil {
+		t.Fatal(err)
+	}
+
+	// new connections should be refused after shutdown
+	if _, err := http.Get("http://" + l.Addr().String()); err == nil {
+		t.Fatal("expected request after shutdown to fail")
+	}
+}
+
+func TestServeHTTPShutdownStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// a stream that only ends when the request context is canceled
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		close(started)
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveHTTP(ctx, l, h, defaultHTTPTimeouts, 5*time.Second)
+	}()
+
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	start := time.Now()
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Fatal(err)
+	} else if time.Since(start) > time.Second {
+		t.Fatal("expected stream to end when shutdown began")
+	}
+}
+
+func TestCheckLoopbackAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:6060", "127.0.0.1:6060", "[::1]:6060"} {
+		if err := checkLoopbackAddr(addr); err != nil {
+			t.Fatalf("expected %q to be allowed: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "192.168.1.1:6060", "example.com:6060", "localhost"} {
+		if err := checkLoopbackAddr(addr); err == nil {
+			t.Fatalf("expected %q to be rejected", addr)
+		}
+	}
+}
+
+func TestWalletdPassword(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password, file, env string
+		expected            string
+	}{
+		{"", "", "", ""},
+		{"from flag", "", "", "from flag"},
+		{"", passwordFile, "", "from file"},
+		{"", "", "from env", "from env"},
+	}
+	for _, test := range tests {
+		if password, err := walletdPassword(test.password, test.file, test.env); err != nil {
+			t.Fatal(err)
+		} else if password != test.expected {
+			t.Fatalf("expected password %q, got %q", test.expected, password)
+		}
+	}
+
+	// only one source can be used
+	if _, err := walletdPassword("from flag", "", "from env"); err == nil {
+		t.Fatal("expected error with multiple sources")
+	} else if _, err := walletdPassword("", passwordFile, "from env"); err == nil {
+		t.Fatal("expected error with multiple sources")
+	} else if _, err := walletdPassword("", filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestWaitForWalletd(t *testing.T) {
+	log := zaptest.NewLogger(t)
+
+	// succeed after one failure
+	var attempts int
+	check := func() error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitForWalletd(context.Background(), check, time.Minute, log); err != nil {
+		t.Fatal(err)
+	} else if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+
+	// give up once the timeout has passed
+	attempts = 0
+	fail := func() error {
+		attempts++
+		return errors.New("connection refused")
+	}
+	if err := waitForWalletd(context.Background(), fail, 0, log); err == nil {
+		t.Fatal("expected error")
+	} else if attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
+
+	// stop when the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForWalletd(ctx, fail, time.Minute, log); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestServeHTTPReadHeaderTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timeouts := defaultHTTPTimeouts
+	timeouts.ReadHeader = 100 * time.Millisecond
+	go serveHTTP(ctx, l, http.NotFoundHandler(), timeouts, time.Second)
+
+	// a client that never finishes its headers should be disconnected
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected connection to be closed after the header timeout, took %v", elapsed)
+	}
+}
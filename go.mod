@@ -3,18 +3,21 @@ module go.sia.tech/cmc-supply-api
 go 1.23.3
 
 require (
+	github.com/coder/websocket v1.8.12
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/shopspring/decimal v1.4.0
 	go.sia.tech/core v0.9.1
+	go.sia.tech/coreutils v0.10.2-0.20250123095304-3c2bc0e93ae1
 	go.sia.tech/jape v0.12.1
 	go.sia.tech/walletd v0.9.0-beta.1.0.20250109165804-3a76ce289ec7
 	go.uber.org/zap v1.27.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	lukechampine.com/frand v1.5.1
 )
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	go.sia.tech/coreutils v0.10.2-0.20250123095304-3c2bc0e93ae1 // indirect
+	go.etcd.io/bbolt v1.3.11 // indirect
 	go.sia.tech/mux v1.3.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+)
+
+// A timeoutClient wraps a ChainClient to abandon requests that take longer
+// than the timeout or outlive the context.
+type timeoutClient struct {
+	client  ChainClient
+	ctx     context.Context
+	timeout time.Duration
+}
+
+// withTimeout calls fn and waits for it to return, the timeout to expire, or
+// ctx to be canceled. The walletd client does not accept a context, so an
+// abandoned request is not canceled. Its goroutine exits when the request
+// returns and the result is discarded.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, fn func() (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		ch <- result{v, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return zero, fmt.Errorf("walletd did not respond within %v: %w", timeout, ctx.Err())
+		}
+		return zero, ctx.Err()
+	}
+}
+
+// ConsensusTip implements ChainClient.
+func (tc *timeoutClient) ConsensusTip() (types.ChainIndex, error) {
+	return withTimeout(tc.ctx, tc.timeout, tc.client.ConsensusTip)
+}
+
+// ConsensusIndex implements ChainClient.
+func (tc *timeoutClient) ConsensusIndex(height uint64) (types.ChainIndex, error) {
+	return withTimeout(tc.ctx, tc.timeout, func() (types.ChainIndex, error) {
+		return tc.client.ConsensusIndex(height)
+	})
+}
+
+// ConsensusUpdates implements ChainClient.
+func (tc *timeoutClient) ConsensusUpdates(index types.ChainIndex, limit int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	type updates struct {
+		reverted []chain.RevertUpdate
+		applied  []chain.ApplyUpdate
+	}
+	u, err := withTimeout(tc.ctx, tc.timeout, func() (updates, error) {
+		reverted, applied, err := tc.client.ConsensusUpdates(index, limit)
+		return updates{reverted, applied}, err
+	})
+	return u.reverted, u.applied, err
+}
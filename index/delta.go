@@ -0,0 +1,244 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+type (
+	// An elementUpdate reports the elements created and spent by a block.
+	// The apply and revert updates of a block report the same elements, so
+	// the changes of a block can be computed from either.
+	elementUpdate interface {
+		ForEachSiacoinElement(func(sce types.SiacoinElement, created, spent bool))
+		ForEachFileContractElement(func(fce types.FileContractElement, created bool, rev *types.FileContractElement, resolved, valid bool))
+		ForEachV2FileContractElement(func(fce types.V2FileContractElement, created bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType))
+	}
+
+	// A supplyChange is the change in supply caused by applying one or more
+	// blocks. Reverting the blocks undoes the same change.
+	supplyChange struct {
+		genesis        types.Currency // outputs of the genesis block
+		reward         types.Currency
+		subsidy        types.Currency
+		matured        types.Currency // emission that matured
+		voidBurned     types.Currency
+		contractBurned types.Currency
+		claims         types.Currency
+		created        types.Currency // non-void outputs created
+		spent          types.Currency // non-void outputs spent
+	}
+
+	// A blockDelta is the change caused by applying a block. It is shared by
+	// the apply, revert and rewind paths so that they always agree.
+	blockDelta struct {
+		supplyChange
+
+		addresses []AddressDelta
+		immature  []MaturityDelta
+		// foundation is the foundation addresses set by the block
+		foundation []FoundationAddress
+	}
+
+	// balanceDeltas accumulates the address and immature supply changes of
+	// a batch of blocks.
+	balanceDeltas struct {
+		addresses  map[types.Address]*AddressDelta
+		maturities map[uint64]*MaturityDelta
+	}
+)
+
+// subCurrency subtracts b from a, returning an error if the result would be
+// negative.
+func subCurrency(a, b types.Currency, field string) (types.Currency, error) {
+	c, underflow := a.SubWithUnderflow(b)
+	if underflow {
+		return types.ZeroCurrency, fmt.Errorf("%s underflow, the index is inconsistent", field)
+	}
+	return c, nil
+}
+
+// add adds the change of another set of blocks to sc.
+func (sc *supplyChange) add(o supplyChange) {
+	sc.genesis = sc.genesis.Add(o.genesis)
+	sc.reward = sc.reward.Add(o.reward)
+	sc.subsidy = sc.subsidy.Add(o.subsidy)
+	sc.matured = sc.matured.Add(o.matured)
+	sc.voidBurned = sc.voidBurned.Add(o.voidBurned)
+	sc.contractBurned = sc.contractBurned.Add(o.contractBurned)
+	sc.claims = sc.claims.Add(o.claims)
+	sc.created = sc.created.Add(o.created)
+	sc.spent = sc.spent.Add(o.spent)
+}
+
+// apply adds the change to the supply of s.
+func (sc supplyChange) apply(s *State) {
+	minted := sc.genesis.Add(sc.reward).Add(sc.subsidy)
+	burned := sc.voidBurned.Add(sc.contractBurned)
+	s.TotalSupply = s.TotalSupply.Add(minted).Sub(burned)
+	s.BurnedSupply = s.BurnedSupply.Add(burned)
+	s.VoidBurnedSupply = s.VoidBurnedSupply.Add(sc.voidBurned)
+	s.ContractBurnedSupply = s.ContractBurnedSupply.Add(sc.contractBurned)
+	s.CirculatingSupply = s.CirculatingSupply.Add(sc.created).Sub(sc.spent)
+	s.FoundationSubsidy = s.FoundationSubsidy.Add(sc.subsidy)
+	s.BlockRewardSupply = s.BlockRewardSupply.Add(sc.reward)
+	s.SiafundClaims = s.SiafundClaims.Add(sc.claims)
+	s.ImmatureSupply = s.ImmatureSupply.Add(sc.reward).Add(sc.subsidy).Sub(sc.matured)
+}
+
+// revert removes the change from the supply of s. An error is returned if
+// the change is larger than the supply it was added to.
+func (sc supplyChange) revert(s *State) (err error) {
+	minted := sc.genesis.Add(sc.reward).Add(sc.subsidy)
+	burned := sc.voidBurned.Add(sc.contractBurned)
+	if s.TotalSupply, err = subCurrency(s.TotalSupply.Add(burned), minted, "total supply"); err != nil {
+		return err
+	} else if s.BurnedSupply, err = subCurrency(s.BurnedSupply, burned, "burned supply"); err != nil {
+		return err
+	} else if s.VoidBurnedSupply, err = subCurrency(s.VoidBurnedSupply, sc.voidBurned, "void burned supply"); err != nil {
+		return err
+	} else if s.ContractBurnedSupply, err = subCurrency(s.ContractBurnedSupply, sc.contractBurned, "contract burned supply"); err != nil {
+		return err
+	} else if s.CirculatingSupply, err = subCurrency(s.CirculatingSupply.Add(sc.spent), sc.created, "circulating supply"); err != nil {
+		return err
+	} else if s.FoundationSubsidy, err = subCurrency(s.FoundationSubsidy, sc.subsidy, "foundation subsidy"); err != nil {
+		return err
+	} else if s.BlockRewardSupply, err = subCurrency(s.BlockRewardSupply, sc.reward, "block reward supply"); err != nil {
+		return err
+	} else if s.SiafundClaims, err = subCurrency(s.SiafundClaims, sc.claims, "siafund claims"); err != nil {
+		return err
+	} else if s.ImmatureSupply, err = subCurrency(s.ImmatureSupply.Add(sc.matured), sc.reward.Add(sc.subsidy), "immature supply"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newBlockDelta returns the change caused by applying b on top of parent. u
+// can be either the apply or the revert update of b.
+func newBlockDelta(parent consensus.State, b types.Block, u elementUpdate) (d blockDelta, err error) {
+	// the parent of the genesis block has the maximum height, so the
+	// genesis height wraps to 0
+	height := parent.Index.Height + 1
+	if height == 0 {
+		// the genesis block has no emission, its outputs are the initial
+		// supply
+		for _, txn := range b.Transactions {
+			for _, sco := range txn.SiacoinOutputs {
+				d.genesis = d.genesis.Add(sco.Value)
+			}
+		}
+		if parent.FoundationSubsidyAddress == types.VoidAddress {
+			return blockDelta{}, errors.New("expected initial foundation address to be set")
+		}
+		d.foundation = append(d.foundation, FoundationAddress{Address: parent.FoundationSubsidyAddress, Role: FoundationRolePrimary})
+		// the testnets do not have a failsafe address
+		if parent.FoundationManagementAddress != types.VoidAddress {
+			d.foundation = append(d.foundation, FoundationAddress{Address: parent.FoundationManagementAddress, Role: FoundationRoleFailsafe})
+		}
+	} else {
+		d.reward, d.subsidy = blockEmission(parent)
+		d.matured = maturedEmission(parent)
+	}
+
+	claims := siafundClaimIDs(b)
+	missed := missedProofOutputIDs(u.ForEachFileContractElement)
+	u.ForEachSiacoinElement(func(sce types.SiacoinElement, created, spent bool) {
+		if created && !spent && claims[sce.ID] {
+			d.claims = d.claims.Add(sce.SiacoinOutput.Value)
+		}
+		switch {
+		case created && spent:
+			return
+		case sce.SiacoinOutput.Address == types.VoidAddress && missed[sce.ID]:
+			// void outputs can't be spent. The missed proof outputs of
+			// expired v1 contracts are contract burns.
+			d.contractBurned = d.contractBurned.Add(sce.SiacoinOutput.Value)
+		case sce.SiacoinOutput.Address == types.VoidAddress:
+			d.voidBurned = d.voidBurned.Add(sce.SiacoinOutput.Value)
+		case created:
+			d.created = d.created.Add(sce.SiacoinOutput.Value)
+			d.addresses = append(d.addresses, AddressDelta{Address: sce.SiacoinOutput.Address, Incoming: sce.SiacoinOutput.Value})
+			// miner payouts, the foundation subsidy, and contract and
+			// siafund claim outputs are immature when created
+			if sce.MaturityHeight > height {
+				d.immature = append(d.immature, MaturityDelta{MaturityHeight: sce.MaturityHeight, Incoming: sce.SiacoinOutput.Value})
+			}
+		case spent:
+			d.spent = d.spent.Add(sce.SiacoinOutput.Value)
+			d.addresses = append(d.addresses, AddressDelta{Address: sce.SiacoinOutput.Address, Outgoing: sce.SiacoinOutput.Value})
+		}
+	})
+
+	u.ForEachV2FileContractElement(func(fce types.V2FileContractElement, created bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType) {
+		// expiration is the only type of resolution that uses the missed host value
+		if _, ok := res.(*types.V2FileContractExpiration); !ok {
+			return
+		}
+		// v2 contracts don't use the void address to burn funds
+		burn, underflow := fce.V2FileContract.HostOutput.Value.SubWithUnderflow(fce.V2FileContract.MissedHostValue)
+		if underflow {
+			return
+		}
+		d.contractBurned = d.contractBurned.Add(burn)
+	})
+
+	d.foundation, err = foundationAddresses(d.foundation, b)
+	if err != nil {
+		return blockDelta{}, err
+	}
+	return d, nil
+}
+
+// add adds the address and immature supply changes of d. If revert is true,
+// the changes are undone instead.
+func (bd *balanceDeltas) add(d blockDelta, revert bool) {
+	for _, ad := range d.addresses {
+		incoming, outgoing := ad.Incoming, ad.Outgoing
+		if revert {
+			incoming, outgoing = outgoing, incoming
+		}
+		if _, ok := bd.addresses[ad.Address]; !ok {
+			bd.addresses[ad.Address] = &AddressDelta{
+				Address: ad.Address,
+			}
+		}
+		bd.addresses[ad.Address].Incoming = bd.addresses[ad.Address].Incoming.Add(incoming)
+		bd.addresses[ad.Address].Outgoing = bd.addresses[ad.Address].Outgoing.Add(outgoing)
+	}
+	for _, md := range d.immature {
+		incoming, outgoing := md.Incoming, md.Outgoing
+		if revert {
+			incoming, outgoing = outgoing, incoming
+		}
+		if _, ok := bd.maturities[md.MaturityHeight]; !ok {
+			bd.maturities[md.MaturityHeight] = &MaturityDelta{
+				MaturityHeight: md.MaturityHeight,
+			}
+		}
+		bd.maturities[md.MaturityHeight].Incoming = bd.maturities[md.MaturityHeight].Incoming.Add(incoming)
+		bd.maturities[md.MaturityHeight].Outgoing = bd.maturities[md.MaturityHeight].Outgoing.Add(outgoing)
+	}
+}
+
+// deltas returns the accumulated address and immature supply changes.
+func (bd *balanceDeltas) deltas() ([]AddressDelta, []MaturityDelta) {
+	addresses := make([]AddressDelta, 0, len(bd.addresses))
+	for _, d := range bd.addresses {
+		addresses = append(addresses, *d)
+	}
+	maturing := make([]MaturityDelta, 0, len(bd.maturities))
+	for _, d := range bd.maturities {
+		maturing = append(maturing, *d)
+	}
+	return addresses, maturing
+}
+
+func newBalanceDeltas() *balanceDeltas {
+	return &balanceDeltas{
+		addresses:  make(map[types.Address]*AddressDelta),
+		maturities: make(map[uint64]*MaturityDelta),
+	}
+}
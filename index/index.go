@@ -4,18 +4,41 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
-	"go.sia.tech/walletd/api"
+	"go.sia.tech/coreutils/chain"
 	"go.uber.org/zap"
 )
 
 type State struct {
 	Index             types.ChainIndex
+	Timestamp         time.Time
 	CirculatingSupply types.Currency
 	TotalSupply       types.Currency
 	BurnedSupply      types.Currency
+	// VoidBurnedSupply is the cumulative value of the outputs sent to the
+	// void address, excluding the missed proof outputs of v1 contracts.
+	VoidBurnedSupply types.Currency
+	// ContractBurnedSupply is the cumulative value burned by expired file
+	// contracts. BurnedSupply is the sum of VoidBurnedSupply and
+	// ContractBurnedSupply.
+	ContractBurnedSupply types.Currency
+	SiafundPool          types.Currency
+	// FoundationSubsidy is the cumulative value of the foundation subsidies
+	// minted up to and including this block.
+	FoundationSubsidy types.Currency
+	// BlockRewardSupply is the cumulative value of the block rewards minted
+	// up to and including this block, excluding transaction fees.
+	BlockRewardSupply types.Currency
+	// SiafundClaims is the cumulative value of the siacoin outputs created
+	// by siafund claims up to and including this block.
+	SiafundClaims types.Currency
+	// ImmatureSupply is the value of the block rewards and foundation
+	// subsidies that have not matured, excluding transaction fees.
+	ImmatureSupply types.Currency
 }
 
 type AddressDelta struct {
@@ -24,195 +47,603 @@ type AddressDelta struct {
 	Outgoing types.Currency
 }
 
+// A MaturityDelta is the change in the value of the immature siacoin outputs
+// that mature at a height. Outputs are locked until the index reaches their
+// maturity height.
+type MaturityDelta struct {
+	MaturityHeight uint64
+	Incoming       types.Currency
+	Outgoing       types.Currency
+}
+
+// An AddressBalance is the siacoin balance of an address.
+type AddressBalance struct {
+	Address types.Address  `json:"address"`
+	Balance types.Currency `json:"balance"`
+}
+
+// An Audit compares the sum of the indexed address balances with the
+// indexed circulating supply. They are equal unless an update was applied
+// incorrectly.
+type Audit struct {
+	Index             types.ChainIndex
+	CirculatingSupply types.Currency
+	AddressBalances   types.Currency
+}
+
+// Consistent returns true if the address balances sum to the circulating
+// supply.
+func (a Audit) Consistent() bool {
+	return a.AddressBalances.Equals(a.CirculatingSupply)
+}
+
+// Foundation address roles
+const (
+	FoundationRolePrimary  FoundationRole = "primary"
+	FoundationRoleFailsafe FoundationRole = "failsafe"
+)
+
+type (
+	// A FoundationRole is the role of a foundation address. The primary
+	// address receives the foundation subsidy; the failsafe address can be
+	// used to recover control of the subsidy.
+	FoundationRole string
+
+	// A FoundationAddress is an address controlled by the Sia Foundation.
+	FoundationAddress struct {
+		Address types.Address
+		Role    FoundationRole
+	}
+)
+
+var (
+	// ErrNotFound is returned when a requested record does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInconsistentSupply is returned when applying updates would result
+	// in a circulating supply greater than the total supply. The updates are
+	// not committed so the index can be inspected.
+	ErrInconsistentSupply = errors.New("circulating supply exceeds total supply")
+)
+
 type Store interface {
 	State() (State, error)
 
-	UpdateState(state State, deltas []AddressDelta, newFoundationAddresses []types.Address) error
+	// UpdateState commits the new state. Foundation addresses in
+	// removedFoundationAddresses are unmarked before the addresses in
+	// newFoundationAddresses are marked.
+	UpdateState(state State, history []State, deltas []AddressDelta, maturityDeltas []MaturityDelta, newFoundationAddresses []FoundationAddress, removedFoundationAddresses []types.Address) error
 }
 
-// UpdateConsensusState indexes consensus updates from the walletd API.
-func UpdateConsensusState(ctx context.Context, store Store, client *api.Client, log *zap.Logger) error {
-	for {
+// A ChainClient provides consensus updates from a walletd node.
+type ChainClient interface {
+	ConsensusTip() (types.ChainIndex, error)
+	ConsensusIndex(height uint64) (types.ChainIndex, error)
+	ConsensusUpdates(index types.ChainIndex, limit int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error)
+}
+
+const (
+	// DefaultBatchSize is the default number of blocks requested from walletd
+	// at a time.
+	DefaultBatchSize = 100
+	// MaxBatchSize is the maximum number of blocks that can be requested from
+	// walletd at a time.
+	MaxBatchSize = 5000
+	// DefaultPollInterval is the default interval between checks for new
+	// blocks once the index is synced.
+	DefaultPollInterval = 15 * time.Second
+	// DefaultRequestTimeout is the default time to wait for a response from
+	// walletd before the request is abandoned and retried.
+	DefaultRequestTimeout = 2 * time.Minute
+
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+
+	// progressLogInterval is the number of blocks between sync progress log
+	// messages.
+	progressLogInterval = 1000
+)
+
+type (
+	// An Option configures the indexer.
+	Option func(*options)
+
+	options struct {
+		BatchSize     int
+		PollInterval  time.Duration
+		Timeout       time.Duration
+		Reindexer     *Reindexer
+		StallMonitor  *StallMonitor
+		ReorgCounter  *ReorgCounter
+		OnStateUpdate func(State)
+		DryRun        bool
+	}
+
+	// dryRunStore wraps a Store to discard updates. The computed state is
+	// kept in memory so indexing continues from it.
+	dryRunStore struct {
+		Store
+		log   *zap.Logger
+		state *State
+	}
+)
+
+// WithPollInterval sets the interval between checks for new blocks once the
+// index is synced with walletd.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.PollInterval = d
+	}
+}
+
+// WithRequestTimeout sets the maximum time to wait for a response from
+// walletd. Requests that take longer are abandoned and retried, so a hung
+// connection does not block the indexer.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.Timeout = d
+	}
+}
+
+// WithBatchSize sets the maximum number of blocks requested from walletd at a
+// time. Larger batches speed up the initial sync.
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.BatchSize = n
+	}
+}
+
+// WithOnStateUpdate sets a function that is called with the new state each
+// time the index changes. It is called after the state is committed, from the
+// indexer's goroutine, so it must not block.
+func WithOnStateUpdate(fn func(State)) Option {
+	return func(o *options) {
+		o.OnStateUpdate = fn
+	}
+}
+
+// WithDryRun runs the indexer without committing updates to the store. The
+// computed state of each block is logged instead, so a new build can be
+// validated against an existing database without changing it.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.DryRun = dryRun
+	}
+}
+
+// WithReindexer allows the index to be rewound using r while the indexer is
+// running.
+func WithReindexer(r *Reindexer) Option {
+	return func(o *options) {
+		o.Reindexer = r
+	}
+}
+
+// WithStallMonitor records each time the index advances in m and logs a
+// warning when it stops advancing for longer than m's threshold.
+func WithStallMonitor(m *StallMonitor) Option {
+	return func(o *options) {
+		o.StallMonitor = m
+	}
+}
+
+// WithReorgCounter counts the blocks applied and reverted by the indexer in
+// c.
+func WithReorgCounter(c *ReorgCounter) Option {
+	return func(o *options) {
+		o.ReorgCounter = c
+	}
+}
+
+// State returns the last computed state, or the store's state if no updates
+// have been computed.
+func (ds *dryRunStore) State() (State, error) {
+	if ds.state != nil {
+		return *ds.state, nil
+	}
+	return ds.Store.State()
+}
+
+// UpdateState logs the computed state of each block instead of committing it.
+func (ds *dryRunStore) UpdateState(state State, history []State, _ []AddressDelta, _ []MaturityDelta, _ []FoundationAddress, _ []types.Address) error {
+	if len(history) == 0 {
+		// only blocks were reverted
+		history = []State{state}
+	}
+	for _, s := range history {
+		ds.log.Info("computed state", zap.Stringer("index", s.Index), zap.Stringer("total", s.TotalSupply), zap.Stringer("circulating", s.CirculatingSupply), zap.Stringer("burned", s.BurnedSupply))
+	}
+	ds.state = &state
+	return nil
+}
+
+// retryInterval returns the duration to wait before retrying after the given
+// number of consecutive failures.
+func retryInterval(failures int) time.Duration {
+	interval := minRetryInterval
+	for i := 1; i < failures && interval < maxRetryInterval; i++ {
+		interval *= 2
+	}
+	return min(interval, maxRetryInterval)
+}
+
+// SyncProgress returns the percentage of the chain up to tip that has been
+// indexed at height.
+func SyncProgress(height, tip uint64) float64 {
+	if tip == 0 || height >= tip {
+		return 100
+	}
+	return float64(height) / float64(tip) * 100
+}
+
+// logProgress logs the sync progress of the index at height. It is only called
+// every progressLogInterval blocks, so the chain tip is not cached.
+func logProgress(client ChainClient, height uint64, log *zap.Logger) {
+	tip, err := client.ConsensusTip()
+	if err != nil {
+		log.Warn("failed to get chain tip", zap.Error(err))
+		return
+	}
+	log.Info("sync progress", zap.Uint64("height", height), zap.Uint64("tip", tip.Height), zap.String("percent", fmt.Sprintf("%.2f%%", SyncProgress(height, tip.Height))))
+}
+
+// foundationAddressUpdates returns the foundation address updates contained in
+// the arbitrary data of txns.
+func foundationAddressUpdates(txns []types.Transaction) ([]types.FoundationAddressUpdate, error) {
+	var updates []types.FoundationAddressUpdate
+	for _, txn := range txns {
+		for _, arb := range txn.ArbitraryData {
+			if !bytes.HasPrefix(arb, types.SpecifierFoundation[:]) {
+				continue
+			}
+			var update types.FoundationAddressUpdate
+			d := types.NewBufDecoder(arb[len(types.SpecifierFoundation):])
+			if update.DecodeFrom(d); d.Err() != nil {
+				return nil, errors.New("transaction contains an improperly-encoded FoundationAddressUpdate")
+			}
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+// foundationAddresses appends the foundation addresses set by the
+// transactions of b to addrs. v1 transactions set the primary and failsafe
+// addresses with a FoundationAddressUpdate. v2 transactions set a single
+// address that both receives the subsidy and manages it.
+func foundationAddresses(addrs []FoundationAddress, b types.Block) ([]FoundationAddress, error) {
+	updates, err := foundationAddressUpdates(b.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	for _, update := range updates {
+		addrs = append(addrs,
+			FoundationAddress{Address: update.NewPrimary, Role: FoundationRolePrimary},
+			FoundationAddress{Address: update.NewFailsafe, Role: FoundationRoleFailsafe},
+		)
+	}
+	for _, txn := range b.V2Transactions() {
+		// setting the void address waives the subsidy without changing the
+		// management address, so there is no new address to track
+		if txn.NewFoundationAddress == nil || *txn.NewFoundationAddress == types.VoidAddress {
+			continue
+		}
+		addrs = append(addrs, FoundationAddress{Address: *txn.NewFoundationAddress, Role: FoundationRolePrimary})
+	}
+	return addrs, nil
+}
+
+// siafundClaimIDs returns the IDs of the siacoin outputs created by the
+// siafund claims of the siafunds spent in b.
+func siafundClaimIDs(b types.Block) map[types.SiacoinOutputID]bool {
+	ids := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range b.Transactions {
+		for _, sfi := range txn.SiafundInputs {
+			ids[sfi.ParentID.ClaimOutputID()] = true
+		}
+	}
+	for _, txn := range b.V2Transactions() {
+		for _, sfi := range txn.SiafundInputs {
+			ids[sfi.Parent.ID.V2ClaimOutputID()] = true
+		}
+	}
+	return ids
+}
+
+// missedProofOutputIDs returns the IDs of the missed proof outputs of the v1
+// contracts that expired in a consensus update. Missed proof outputs sent to
+// the void address are contract burns rather than explicit burns.
+func missedProofOutputIDs(forEach func(func(types.FileContractElement, bool, *types.FileContractElement, bool, bool))) map[types.SiacoinOutputID]bool {
+	ids := make(map[types.SiacoinOutputID]bool)
+	forEach(func(fce types.FileContractElement, _ bool, rev *types.FileContractElement, resolved, valid bool) {
+		if !resolved || valid {
+			return
+		}
+		fc := fce.FileContract
+		if rev != nil {
+			fc = rev.FileContract
+		}
+		for i := range fc.MissedProofOutputs {
+			ids[fce.ID.MissedOutputID(i)] = true
+		}
+	})
+	return ids
+}
+
+// blockEmission returns the block reward and foundation subsidy minted by the
+// child of parent. The emission only depends on the height, so the apply and
+// revert paths must both use the parent's height.
+func blockEmission(parent consensus.State) (reward, subsidy types.Currency) {
+	reward = parent.BlockReward()
+	if sco, ok := parent.FoundationSubsidy(); ok {
+		subsidy = sco.Value
+	}
+	return
+}
+
+// maturedEmission returns the block reward and foundation subsidy that mature
+// in the child of parent. Both are immature for MaturityDelay blocks, so they
+// are the emission of the block MaturityDelay blocks before the child.
+func maturedEmission(parent consensus.State) types.Currency {
+	childHeight := parent.Index.Height + 1
+	if childHeight <= parent.Network.MaturityDelay {
+		return types.ZeroCurrency // the genesis block has no emission
+	}
+	matured := parent
+	matured.Index.Height = childHeight - parent.Network.MaturityDelay - 1
+	reward, subsidy := blockEmission(matured)
+	return reward.Add(subsidy)
+}
+
+// applyUpdates applies a batch of consensus updates on top of state and
+// commits the result to the store.
+func applyUpdates(store Store, state State, reverted []chain.RevertUpdate, applied []chain.ApplyUpdate, log *zap.Logger) error {
+	balances := newBalanceDeltas()
+	var removedFoundationAddresses []types.Address
+	for _, cru := range reverted {
+		// cru.State.Index is the parent of the reverted block
+		// calculate the index of the block that was reverted
+		revertedIndex := types.ChainIndex{
+			ID:     cru.Block.ID(),
+			Height: cru.State.Index.Height + 1,
+		}
+		log := log.With(zap.Stringer("blockID", revertedIndex.ID), zap.Uint64("height", revertedIndex.Height))
+
+		// cru.State is the parent state, so it is used to calculate the
+		// changes of the reverted block
+		d, err := newBlockDelta(cru.State, cru.Block, cru)
+		if err != nil {
+			return err
+		} else if err := d.revert(&state); err != nil {
+			return fmt.Errorf("failed to revert block %v: %w", revertedIndex, err)
+		}
+		balances.add(d, true)
+
+		// unmark any foundation addresses added by the reverted block. The
+		// addresses in the parent state are still in use and must be kept.
+		// The genesis block has no parent, so all of its addresses are
+		// removed.
+		for _, fa := range d.foundation {
+			if revertedIndex.Height != 0 && (fa.Address == cru.State.FoundationSubsidyAddress || fa.Address == cru.State.FoundationManagementAddress) {
+				continue
+			}
+			removedFoundationAddresses = append(removedFoundationAddresses, fa.Address)
+		}
+
+		log.Debug("reverted index", zap.Stringer("total", state.TotalSupply), zap.Stringer("circulating", state.CirculatingSupply), zap.Stringer("burned", state.BurnedSupply))
+		state.Index = cru.State.Index
+		if revertedIndex.Height == 0 {
+			// the parent of the genesis block has no index, so the index
+			// is uninitialized again
+			state.Index = types.ChainIndex{}
+		}
+		state.Timestamp = cru.State.PrevTimestamps[0] // timestamp of the parent block
+		state.SiafundPool = cru.State.SiafundTaxRevenue
+	}
+
+	var newFoundationAddresses []FoundationAddress
+	history := make([]State, 0, len(applied))
+	for _, cau := range applied {
+		index := cau.State.Index
+		log := log.With(zap.Stringer("blockID", index.ID), zap.Uint64("height", index.Height))
+
+		// cau.State is post-apply, need to get the pre-apply state to avoid an off-by-one
+		parentState := cau.State
+		parentState.Index.Height--
+		d, err := newBlockDelta(parentState, cau.Block, cau)
+		if err != nil {
+			return err
+		}
+		d.apply(&state)
+		balances.add(d, false)
+		newFoundationAddresses = append(newFoundationAddresses, d.foundation...)
+
+		state.Index = cau.State.Index
+		state.Timestamp = cau.Block.Timestamp
+		state.SiafundPool = cau.State.SiafundTaxRevenue
+		history = append(history, state)
+		log.Debug("applied index", zap.Stringer("total", state.TotalSupply), zap.Stringer("circulating", state.CirculatingSupply), zap.Stringer("burned", state.BurnedSupply))
+	}
+
+	if state.TotalSupply.Cmp(state.CirculatingSupply) < 0 {
+		log.Error("inconsistent supply", zap.Stringer("index", state.Index), zap.Stringer("total", state.TotalSupply), zap.Stringer("circulating", state.CirculatingSupply))
+		return fmt.Errorf("%w at height %d: total %v, circulating %v", ErrInconsistentSupply, state.Index.Height, state.TotalSupply, state.CirculatingSupply)
+	}
+
+	deltas, maturing := balances.deltas()
+	if err := store.UpdateState(state, history, deltas, maturing, newFoundationAddresses, removedFoundationAddresses); err != nil {
+		return fmt.Errorf("failed to update state: %w", err)
+	}
+	return nil
+}
+
+// consensusUpdates requests the next batch of updates after index. If the
+// chain reorged deeper than the batch size, the reverts are requested until
+// the first applied block so that the index is never committed in a partially
+// rewound state.
+func consensusUpdates(client ChainClient, index types.ChainIndex, batchSize int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	reverted, applied, err := client.ConsensusUpdates(index, batchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(reverted) > 0 && len(applied) == 0 {
+		// the parent of the last reverted block is the next index to
+		// revert from
+		parent := reverted[len(reverted)-1].State.Index
+		r, a, err := client.ConsensusUpdates(parent, batchSize)
+		if err != nil {
+			return nil, nil, err
+		} else if len(r) == 0 && len(a) == 0 {
+			break
+		}
+		reverted = append(reverted, r...)
+		applied = a
+	}
+	return reverted, applied, nil
+}
+
+// UpdateConsensusState indexes consensus updates from the walletd API. New
+// batches are requested immediately while the index is behind. Once the index
+// is synced, walletd is polled for new blocks every poll interval.
+func UpdateConsensusState(ctx context.Context, store Store, client ChainClient, log *zap.Logger, opts ...Option) error {
+	o := options{
+		BatchSize:    DefaultBatchSize,
+		PollInterval: DefaultPollInterval,
+		Timeout:      DefaultRequestTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.BatchSize <= 0 || o.BatchSize > MaxBatchSize {
+		return fmt.Errorf("batch size must be between 1 and %d", MaxBatchSize)
+	} else if o.PollInterval <= 0 {
+		return errors.New("poll interval must be positive")
+	} else if o.Timeout <= 0 {
+		return errors.New("request timeout must be positive")
+	}
+	client = &timeoutClient{client: client, ctx: ctx, timeout: o.Timeout}
+
+	if o.DryRun {
+		log.Warn("dry run enabled, updates will not be committed")
+		store = &dryRunStore{Store: store, log: log.Named("dryrun")}
+	}
+
+	// reindex requests are handled between batches so they never race
+	// with applying updates
+	var reindexCh chan reindexRequest
+	if o.Reindexer != nil {
+		reindexCh = o.Reindexer.reqs
+	}
+	// stateUpdated notifies the caller that a new state was committed
+	stateUpdated := func() {
+		if o.OnStateUpdate == nil {
+			return
+		}
+		state, err := store.State()
+		if err != nil {
+			log.Warn("failed to get updated state", zap.Error(err))
+			return
+		}
+		o.OnStateUpdate(state)
+	}
+
+	handleReindex := func(req reindexRequest) {
+		err := rewind(store, client, req.from, o.BatchSize, log)
+		if err != nil {
+			log.Error("failed to rewind index", zap.Uint64("from", req.from), zap.Error(err))
+		} else {
+			stateUpdated()
+		}
+		req.errCh <- err
+	}
+
+	// sleep waits for d, until a reindex is requested, or until the context
+	// is canceled
+	sleep := func(d time.Duration) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(15 * time.Second):
+		case req := <-reindexCh:
+			handleReindex(req)
+			return nil
+		case <-time.After(d):
+			return nil
 		}
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+	// checkStall records the current index in the stall monitor. synced is
+	// true if walletd has no new blocks, so a stall means the chain itself
+	// is stuck rather than the indexer.
+	checkStall := func(index types.ChainIndex, synced bool) {
+		if o.StallMonitor == nil {
+			return
+		}
+		stalled, changed, since := o.StallMonitor.observe(index)
+		switch {
+		case !changed:
+		case stalled && synced:
+			log.Warn("chain tip stalled, the index is synced but walletd has not reported a new block", zap.Stringer("index", index), zap.Duration("since", since))
+		case stalled:
+			log.Warn("index stalled, no new blocks have been indexed", zap.Stringer("index", index), zap.Duration("since", since))
+		default:
+			log.Info("index advanced after a stall", zap.Stringer("index", index))
+		}
+	}
 
-			state, err := store.State()
-			if err != nil {
-				log.Fatal("failed to get last index", zap.Error(err))
-			}
+	var failures int
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case req := <-reindexCh:
+			handleReindex(req)
+			continue
+		default:
+		}
 
-			reverted, applied, err := client.ConsensusUpdates(state.Index, 100)
-			if err != nil {
-				log.Fatal("failed to get consensus updates", zap.Error(err))
-			} else if len(reverted) == 0 && len(applied) == 0 {
-				continue
-			}
+		state, err := store.State()
+		if err != nil {
+			return fmt.Errorf("failed to get last index: %w", err)
+		}
 
-			addressDeltas := make(map[types.Address]*AddressDelta)
-			incrementAddressDelta := func(addr types.Address, incoming, outgoing types.Currency) {
-				if _, ok := addressDeltas[addr]; !ok {
-					addressDeltas[addr] = &AddressDelta{
-						Address: addr,
-					}
-				}
-				addressDeltas[addr].Incoming = addressDeltas[addr].Incoming.Add(incoming)
-				addressDeltas[addr].Outgoing = addressDeltas[addr].Outgoing.Add(outgoing)
-			}
-			for _, cru := range reverted {
-				// cru.State.Index is the parent of the reverted block
-				// calculate the index of the block that was reverted
-				revertedIndex := types.ChainIndex{
-					ID:     cru.Block.ID(),
-					Height: cru.State.Index.Height + 1,
-				}
-				log := log.With(zap.Stringer("blockID", revertedIndex.ID), zap.Uint64("height", revertedIndex.Height))
-
-				// state is already the post-reverted state
-				state.TotalSupply = state.TotalSupply.Sub(cru.State.BlockReward())
-				sco, ok := cru.State.FoundationSubsidy()
-				if ok {
-					state.TotalSupply = state.TotalSupply.Sub(sco.Value)
-				}
-
-				cru.ForEachSiacoinElement(func(sce types.SiacoinElement, created, spent bool) {
-					switch {
-					case created && spent:
-						return
-					case sce.SiacoinOutput.Address == types.VoidAddress:
-						// void outputs can't be spent, revert the burn
-						state.TotalSupply = state.TotalSupply.Add(sce.SiacoinOutput.Value)
-						state.BurnedSupply = state.BurnedSupply.Sub(sce.SiacoinOutput.Value)
-					case created:
-						incrementAddressDelta(sce.SiacoinOutput.Address, types.ZeroCurrency, sce.SiacoinOutput.Value)
-						state.CirculatingSupply = state.CirculatingSupply.Sub(sce.SiacoinOutput.Value)
-					case spent:
-						incrementAddressDelta(sce.SiacoinOutput.Address, sce.SiacoinOutput.Value, types.ZeroCurrency)
-						state.CirculatingSupply = state.CirculatingSupply.Add(sce.SiacoinOutput.Value)
-					}
-				})
-
-				cru.ForEachV2FileContractElement(func(fce types.V2FileContractElement, created bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType) {
-					if res == nil {
-						return
-					}
-
-					// expiration is the only type of resolution that uses the missed host value
-					_, ok := res.(*types.V2FileContractExpiration)
-					if !ok {
-						return
-					}
-					// v2 contracts don't use the void address to burn funds
-					burn, ok := fce.V2FileContract.HostOutput.Value.SubWithUnderflow(fce.V2FileContract.MissedHostValue)
-					if !ok {
-						return
-					}
-					state.BurnedSupply = state.BurnedSupply.Sub(burn)
-					state.TotalSupply = state.TotalSupply.Add(burn)
-				})
-
-				log.Debug("reverted index", zap.Stringer("total", state.TotalSupply), zap.Stringer("circulating", state.CirculatingSupply), zap.Stringer("burned", state.BurnedSupply))
-				state.Index = cru.State.Index
+		reverted, applied, err := consensusUpdates(client, state.Index, o.BatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-
-			var newFoundationAddresses []types.Address
-			for _, cau := range applied {
-				index := cau.State.Index
-				log := log.With(zap.Stringer("blockID", index.ID), zap.Uint64("height", index.Height))
-
-				if index.Height == 0 {
-					for _, txn := range cau.Block.Transactions {
-						for _, sco := range txn.SiacoinOutputs {
-							state.TotalSupply = state.TotalSupply.Add(sco.Value)
-						}
-					}
-					if cau.State.FoundationManagementAddress == types.VoidAddress {
-						log.Panic("expected initial foundation address to be set")
-					}
-					newFoundationAddresses = append(newFoundationAddresses, cau.State.FoundationManagementAddress)
-				} else {
-					// cau.State is post-apply, need to get the pre-apply state to avoid an off-by-one
-					parentState := cau.State
-					parentState.Index.Height--
-					state.TotalSupply = state.TotalSupply.Add(parentState.BlockReward())
-					sco, ok := parentState.FoundationSubsidy()
-					if ok {
-						state.TotalSupply = state.TotalSupply.Add(sco.Value)
-					}
-				}
-
-				cau.ForEachSiacoinElement(func(sce types.SiacoinElement, created, spent bool) {
-					switch {
-					case created && spent:
-						return
-					case sce.SiacoinOutput.Address == types.VoidAddress:
-						// void outputs can't be spent, add the burn
-						state.BurnedSupply = state.BurnedSupply.Add(sce.SiacoinOutput.Value)
-						state.TotalSupply = state.TotalSupply.Sub(sce.SiacoinOutput.Value)
-					case created:
-						incrementAddressDelta(sce.SiacoinOutput.Address, sce.SiacoinOutput.Value, types.ZeroCurrency)
-						state.CirculatingSupply = state.CirculatingSupply.Add(sce.SiacoinOutput.Value)
-					case spent:
-						incrementAddressDelta(sce.SiacoinOutput.Address, types.ZeroCurrency, sce.SiacoinOutput.Value)
-						state.CirculatingSupply = state.CirculatingSupply.Sub(sce.SiacoinOutput.Value)
-					}
-				})
-
-				cau.ForEachV2FileContractElement(func(fce types.V2FileContractElement, created bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType) {
-					if res == nil {
-						return
-					}
-
-					// expiration is the only type of resolution that uses the missed host value
-					_, ok := res.(*types.V2FileContractExpiration)
-					if !ok {
-						return
-					}
-					// v2 contracts don't use the void address to burn funds
-					burn, ok := fce.V2FileContract.HostOutput.Value.SubWithUnderflow(fce.V2FileContract.MissedHostValue)
-					if !ok {
-						return
-					}
-					state.BurnedSupply = state.BurnedSupply.Add(burn)
-					state.TotalSupply = state.TotalSupply.Sub(burn)
-				})
-
-				for _, txn := range cau.Block.Transactions {
-					for _, arb := range txn.ArbitraryData {
-						if !bytes.HasPrefix(arb, types.SpecifierFoundation[:]) {
-							continue
-						}
-						var update types.FoundationAddressUpdate
-						d := types.NewBufDecoder(arb[len(types.SpecifierFoundation):])
-						if update.DecodeFrom(d); d.Err() != nil {
-							return errors.New("transaction contains an improperly-encoded FoundationAddressUpdate")
-						}
-						newFoundationAddresses = append(newFoundationAddresses, update.NewPrimary)
-					}
-				}
-				state.Index = cau.State.Index
-				log.Debug("applied index", zap.Stringer("total", state.TotalSupply), zap.Stringer("circulating", state.CirculatingSupply), zap.Stringer("burned", state.BurnedSupply))
+			// walletd may be temporarily unavailable, retry with
+			// exponential backoff
+			failures++
+			retry := retryInterval(failures)
+			log.Warn("failed to get consensus updates", zap.Int("attempt", failures), zap.Duration("retry", retry), zap.Error(err))
+			checkStall(state.Index, false)
+			if err := sleep(retry); err != nil {
+				return err
 			}
+			continue
+		}
+		failures = 0
 
-			if state.TotalSupply.Cmp(state.CirculatingSupply) < 0 {
-				panic("total supply < circulating supply")
+		if len(reverted) == 0 && len(applied) == 0 {
+			// the index is synced, wait for new blocks
+			checkStall(state.Index, true)
+			if err := sleep(o.PollInterval); err != nil {
+				return err
 			}
+			continue
+		} else if err := applyUpdates(store, state, reverted, applied, log); err != nil {
+			return fmt.Errorf("failed to apply updates: %w", err)
+		}
+		stateUpdated()
+		if o.ReorgCounter != nil {
+			o.ReorgCounter.record(len(reverted), len(applied))
+		}
 
-			deltas := make([]AddressDelta, len(addressDeltas))
-			for _, d := range addressDeltas {
-				deltas = append(deltas, *d)
-			}
-			if err := store.UpdateState(state, deltas, newFoundationAddresses); err != nil {
-				log.Fatal("failed to update state", zap.Error(err))
+		if n := len(applied); n > 0 {
+			checkStall(applied[n-1].State.Index, false)
+			height := applied[n-1].State.Index.Height
+			if height/progressLogInterval != state.Index.Height/progressLogInterval {
+				logProgress(client, height, log)
 			}
 		}
 	}
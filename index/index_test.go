@@ -0,0 +1,1427 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	wapi "go.sia.tech/walletd/api"
+	"go.uber.org/zap/zaptest"
+	"lukechampine.com/frand"
+)
+
+// memStore is an in-memory Store used for testing.
+type memStore struct {
+	mu         sync.Mutex
+	state      State
+	balances   map[types.Address]types.Currency
+	foundation map[types.Address]FoundationRole
+	immature   map[uint64]types.Currency
+	deltas     [][]AddressDelta
+}
+
+func (ms *memStore) State() (State, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state, nil
+}
+
+func (ms *memStore) UpdateState(state State, _ []State, deltas []AddressDelta, maturityDeltas []MaturityDelta, newFoundationAddresses []FoundationAddress, removedFoundationAddresses []types.Address) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, addr := range removedFoundationAddresses {
+		delete(ms.foundation, addr)
+	}
+	for _, fa := range newFoundationAddresses {
+		ms.foundation[fa.Address] = fa.Role
+	}
+	for _, d := range deltas {
+		ms.balances[d.Address] = ms.balances[d.Address].Add(d.Incoming).Sub(d.Outgoing)
+	}
+	for _, d := range maturityDeltas {
+		ms.immature[d.MaturityHeight] = ms.immature[d.MaturityHeight].Add(d.Incoming).Sub(d.Outgoing)
+		if ms.immature[d.MaturityHeight].IsZero() {
+			delete(ms.immature, d.MaturityHeight)
+		}
+	}
+	ms.state = state
+	ms.deltas = append(ms.deltas, deltas)
+	return nil
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		balances:   make(map[types.Address]types.Currency),
+		foundation: make(map[types.Address]FoundationRole),
+		immature:   make(map[uint64]types.Currency),
+	}
+}
+
+// locked returns the value of the outputs that are immature at ms's current
+// height.
+func (ms *memStore) locked() (locked types.Currency) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for height, value := range ms.immature {
+		if height > ms.state.Index.Height {
+			locked = locked.Add(value)
+		}
+	}
+	return
+}
+
+// managerClient adapts a chain.Manager to the ChainClient interface.
+type managerClient struct {
+	cm *chain.Manager
+}
+
+func (mc managerClient) ConsensusTip() (types.ChainIndex, error) {
+	return mc.cm.Tip(), nil
+}
+
+func (mc managerClient) ConsensusIndex(height uint64) (types.ChainIndex, error) {
+	index, ok := mc.cm.BestIndex(height)
+	if !ok {
+		return types.ChainIndex{}, fmt.Errorf("no index at height %d", height)
+	}
+	return index, nil
+}
+
+func (mc managerClient) ConsensusUpdates(index types.ChainIndex, limit int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	return mc.cm.UpdatesSince(index, limit)
+}
+
+func newTestChain(t *testing.T) *chain.Manager {
+	t.Helper()
+	return newFoundationTestChain(t, frand.Entropy256(), frand.Entropy256())
+}
+
+// newFoundationTestChain returns a test chain with the given initial
+// foundation addresses.
+func newFoundationTestChain(t *testing.T, primary, failsafe types.Address) *chain.Manager {
+	t.Helper()
+
+	n, genesisBlock := testutil.Network()
+	return newNetworkTestChain(t, n, genesisBlock, primary, failsafe)
+}
+
+// newV2TestChain returns a test chain that requires v2 transactions from
+// height 1 with the given initial foundation addresses.
+func newV2TestChain(t *testing.T, primary, failsafe types.Address) *chain.Manager {
+	t.Helper()
+
+	n, genesisBlock := testutil.V2Network()
+	return newNetworkTestChain(t, n, genesisBlock, primary, failsafe)
+}
+
+func newNetworkTestChain(t *testing.T, n *consensus.Network, genesisBlock types.Block, primary, failsafe types.Address) *chain.Manager {
+	t.Helper()
+
+	// the indexer expects the foundation addresses to be set at genesis
+	n.HardforkFoundation.PrimaryAddress = primary
+	n.HardforkFoundation.FailsafeAddress = failsafe
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain.NewManager(store, tipState)
+}
+
+// syncStore applies consensus updates from cm to ms until ms is synced with
+// cm's tip.
+func syncStore(t *testing.T, ms *memStore, cm *chain.Manager, batchSize int) {
+	t.Helper()
+
+	log := zaptest.NewLogger(t)
+	for ms.state.Index != cm.Tip() {
+		reverted, applied, err := cm.UpdatesSince(ms.state.Index, batchSize)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := applyUpdates(ms, ms.state, reverted, applied, log); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// appliedUpdate returns the apply update of the block at height. The
+// elements it reports have proofs that are valid at the block's index.
+func appliedUpdate(t *testing.T, cm *chain.Manager, height uint64) chain.ApplyUpdate {
+	t.Helper()
+
+	parent, ok := cm.BestIndex(height - 1)
+	if !ok {
+		t.Fatalf("missing index at height %d", height-1)
+	}
+	_, applied, err := cm.UpdatesSince(parent, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(applied) != 1 {
+		t.Fatalf("expected 1 applied update, got %d", len(applied))
+	}
+	return applied[0]
+}
+
+// minerPayout mines a block to addr and returns its miner payout once it has
+// matured, along with the index its proof is valid for.
+func minerPayout(t *testing.T, cm *chain.Manager, addr types.Address) (types.ChainIndex, types.SiacoinElement) {
+	t.Helper()
+
+	testutil.MineBlocks(t, cm, addr, 1)
+	au := appliedUpdate(t, cm, cm.Tip().Height)
+	id := cm.Tip().ID.MinerOutputID(0)
+	var payout types.SiacoinElement
+	au.ForEachSiacoinElement(func(sce types.SiacoinElement, created, _ bool) {
+		if created && sce.ID == id {
+			payout = sce
+		}
+	})
+	if payout.ID != id {
+		t.Fatal("missing miner payout")
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(cm.TipState().Network.MaturityDelay))
+	return au.State.Index, payout
+}
+
+// reorgChain mines a fork of cm starting at height and adds it to cm. The fork
+// is mined until it is longer than cm's current chain.
+func reorgChain(t *testing.T, cm *chain.Manager, height uint64) {
+	t.Helper()
+
+	blockAt := func(height uint64) types.Block {
+		index, ok := cm.BestIndex(height)
+		if !ok {
+			t.Fatalf("missing index at height %d", height)
+		}
+		b, ok := cm.Block(index.ID)
+		if !ok {
+			t.Fatalf("missing block %v", index)
+		}
+		return b
+	}
+
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), cm.TipState().Network, blockAt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork := chain.NewManager(store, tipState)
+	var blocks []types.Block
+	for h := uint64(1); h <= height; h++ {
+		blocks = append(blocks, blockAt(h))
+	}
+	if err := fork.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, fork, frand.Entropy256(), int(cm.Tip().Height-height)+1)
+
+	blocks = blocks[:0]
+	for h := height + 1; h <= fork.Tip().Height; h++ {
+		index, _ := fork.BestIndex(h)
+		b, _ := fork.Block(index.ID)
+		blocks = append(blocks, b)
+	}
+	if err := cm.AddBlocks(blocks); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != fork.Tip() {
+		t.Fatalf("expected tip %v after reorg, got %v", fork.Tip(), cm.Tip())
+	}
+}
+
+func TestUpdateIndexDeltas(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+
+	for i := 0; i < 5; i++ {
+		testutil.MineBlocks(t, cm, frand.Entropy256(), 2)
+	}
+
+	// determine the set of addresses touched by the chain
+	_, applied, err := cm.UpdatesSince(types.ChainIndex{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touched := make(map[types.Address]bool)
+	for _, cau := range applied {
+		cau.ForEachSiacoinElement(func(sce types.SiacoinElement, created, spent bool) {
+			if (created && spent) || sce.SiacoinOutput.Address == types.VoidAddress {
+				return
+			}
+			touched[sce.SiacoinOutput.Address] = true
+		})
+	}
+
+	ms := newMemStore()
+	if err := applyUpdates(ms, ms.state, nil, applied, log); err != nil {
+		t.Fatal(err)
+	} else if ms.state.Index != cm.Tip() {
+		t.Fatalf("expected tip %v, got %v", cm.Tip(), ms.state.Index)
+	} else if len(ms.deltas) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(ms.deltas))
+	}
+
+	deltas := ms.deltas[0]
+	if len(deltas) != len(touched) {
+		t.Fatalf("expected %d deltas, got %d", len(touched), len(deltas))
+	}
+	for _, d := range deltas {
+		if !touched[d.Address] {
+			t.Fatalf("unexpected delta for address %v", d.Address)
+		}
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, maxRetryInterval},
+		{100, maxRetryInterval},
+	}
+	for _, test := range tests {
+		if interval := retryInterval(test.failures); interval != test.expected {
+			t.Errorf("expected %v after %d failures, got %v", test.expected, test.failures, interval)
+		}
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	cm := newTestChain(t)
+
+	for i := 0; i < 5; i++ {
+		testutil.MineBlocks(t, cm, frand.Entropy256(), 5)
+	}
+
+	small, large := newMemStore(), newMemStore()
+	syncStore(t, small, cm, 1)
+	syncStore(t, large, cm, MaxBatchSize)
+	if small.state != large.state {
+		t.Fatalf("expected state %+v, got %+v", large.state, small.state)
+	} else if len(small.balances) != len(large.balances) {
+		t.Fatalf("expected %d balances, got %d", len(large.balances), len(small.balances))
+	}
+	for addr, balance := range large.balances {
+		if !small.balances[addr].Equals(balance) {
+			t.Fatalf("expected balance %v for address %v, got %v", balance, addr, small.balances[addr])
+		}
+	}
+}
+
+func TestUpdateConsensusState(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 20)
+
+	ms := newMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithBatchSize(3), WithPollInterval(10*time.Millisecond))
+	}()
+
+	waitForTip := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			state, _ := ms.State()
+			if state.Index == cm.Tip() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("index did not sync to %v", cm.Tip())
+	}
+
+	waitForTip()
+	// mine more blocks and check the indexer picks them up
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 5)
+	waitForTip()
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestOnStateUpdate(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	// the callback is called with each committed state
+	updates := make(chan State, 100)
+	ms := newMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reindexer := NewReindexer()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithBatchSize(4), WithPollInterval(10*time.Millisecond), WithReindexer(reindexer), WithOnStateUpdate(func(s State) { updates <- s }))
+	}()
+
+	waitForUpdate := func(index types.ChainIndex) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case s := <-updates:
+				if s.Index == index {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no update for %v", index)
+			}
+		}
+	}
+	waitForUpdate(cm.Tip())
+
+	// rewinding also updates the state
+	if err := reindexer.Reindex(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	parent, _ := cm.BestIndex(4)
+	waitForUpdate(parent)
+	waitForUpdate(cm.Tip())
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	ms := newMemStore()
+	updates := make(chan State, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithBatchSize(3), WithPollInterval(10*time.Millisecond), WithDryRun(true), WithOnStateUpdate(func(s State) { updates <- s }))
+	}()
+
+	// the computed state should match a committed index
+	fresh := newMemStore()
+	syncStore(t, fresh, cm, 10)
+	timeout := time.After(5 * time.Second)
+	for synced := false; !synced; {
+		select {
+		case s := <-updates:
+			synced = s.Index == cm.Tip()
+			if synced && s != fresh.state {
+				t.Fatalf("expected state %+v, got %+v", fresh.state, s)
+			}
+		case <-timeout:
+			t.Fatalf("no update for %v", cm.Tip())
+		}
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+
+	// nothing should be committed
+	if ms.state != (State{}) {
+		t.Fatalf("expected empty state, got %+v", ms.state)
+	} else if len(ms.deltas) != 0 || len(ms.balances) != 0 || len(ms.foundation) != 0 {
+		t.Fatal("expected no committed updates")
+	}
+}
+
+func TestV1ContractBurn(t *testing.T) {
+	cm := newTestChain(t)
+
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	addr := uc.UnlockHash()
+
+	// mine a block to the renter and wait for the payout to mature
+	testutil.MineBlocks(t, cm, addr, 1)
+	formationHeight := cm.Tip().Height
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(cm.TipState().Network.MaturityDelay))
+
+	index, _ := cm.BestIndex(formationHeight)
+	b, _ := cm.Block(index.ID)
+	parentID := index.ID.MinerOutputID(0)
+	payout := b.MinerPayouts[0].Value
+
+	// form a contract that burns part of the host's collateral if no proof is
+	// submitted
+	cs := cm.TipState()
+	fc := types.FileContract{
+		WindowStart: cs.Index.Height + 3,
+		WindowEnd:   cs.Index.Height + 5,
+		Payout:      payout,
+	}
+	value := payout.Sub(cs.FileContractTax(fc))
+	renterValue := value.Div64(2)
+	hostValue := value.Sub(renterValue)
+	burn := hostValue.Div64(2)
+	hostAddr := types.Address(frand.Entropy256())
+	fc.ValidProofOutputs = []types.SiacoinOutput{
+		{Address: addr, Value: renterValue},
+		{Address: hostAddr, Value: hostValue},
+	}
+	fc.MissedProofOutputs = []types.SiacoinOutput{
+		{Address: addr, Value: renterValue},
+		{Address: hostAddr, Value: hostValue.Sub(burn)},
+		{Address: types.VoidAddress, Value: burn},
+	}
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         parentID,
+			UnlockConditions: uc,
+		}},
+		FileContracts: []types.FileContract{fc},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(parentID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	sig := sk.SignHash(cs.WholeSigHash(txn, types.Hash256(parentID), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	contractHeight := cm.Tip().Height
+	// mine until the contract expires
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(fc.WindowEnd-contractHeight)+1)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	if !ms.state.BurnedSupply.Equals(burn) {
+		t.Fatalf("expected burned supply %v, got %v", burn, ms.state.BurnedSupply)
+	} else if !ms.state.ContractBurnedSupply.Equals(burn) {
+		t.Fatalf("expected contract burned supply %v, got %v", burn, ms.state.ContractBurnedSupply)
+	} else if !ms.state.VoidBurnedSupply.IsZero() {
+		t.Fatalf("expected no void burned supply, got %v", ms.state.VoidBurnedSupply)
+	}
+
+	// revert the contract
+	reorgChain(t, cm, contractHeight)
+	syncStore(t, ms, cm, 10)
+	if !ms.state.BurnedSupply.IsZero() || !ms.state.ContractBurnedSupply.IsZero() {
+		t.Fatalf("expected no burned supply, got %v", ms.state.BurnedSupply)
+	}
+
+	// the reverted state should match a fresh sync
+	fresh := newMemStore()
+	syncStore(t, fresh, cm, 10)
+	if ms.state != fresh.state {
+		t.Fatalf("expected state %+v, got %+v", fresh.state, ms.state)
+	}
+}
+
+func TestV2ContractBurn(t *testing.T) {
+	cm := newV2TestChain(t, frand.Entropy256(), frand.Entropy256())
+
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	addr := policy.Address()
+	basis, sce := minerPayout(t, cm, addr)
+
+	// form a contract that burns part of the host's collateral if it expires
+	// without a proof
+	cs := cm.TipState()
+	renterValue := sce.SiacoinOutput.Value.Div64(4)
+	hostValue := sce.SiacoinOutput.Value.Div64(4)
+	burn := hostValue.Div64(2)
+	fc := types.V2FileContract{
+		ProofHeight:      cs.Index.Height + 3,
+		ExpirationHeight: cs.Index.Height + 5,
+		RenterOutput:     types.SiacoinOutput{Address: addr, Value: renterValue},
+		HostOutput:       types.SiacoinOutput{Address: frand.Entropy256(), Value: hostValue},
+		MissedHostValue:  hostValue.Sub(burn),
+		RenterPublicKey:  sk.PublicKey(),
+		HostPublicKey:    sk.PublicKey(),
+	}
+	fc.RenterSignature = sk.SignHash(cs.ContractSigHash(fc))
+	fc.HostSignature = fc.RenterSignature
+
+	change := sce.SiacoinOutput.Value.Sub(renterValue).Sub(hostValue).Sub(cs.V2FileContractTax(fc))
+	txn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          sce,
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: change}},
+		FileContracts:  []types.V2FileContract{fc},
+	}
+	txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+	if _, err := cm.AddV2PoolTransactions(basis, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	contractHeight := cm.Tip().Height
+
+	au := appliedUpdate(t, cm, contractHeight)
+	var fce types.V2FileContractElement
+	au.ForEachV2FileContractElement(func(e types.V2FileContractElement, created bool, _ *types.V2FileContractElement, _ types.V2FileContractResolutionType) {
+		if created {
+			fce = e
+		}
+	})
+
+	// expire the contract
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(fc.ExpirationHeight-contractHeight))
+	resolution := types.V2Transaction{
+		FileContractResolutions: []types.V2FileContractResolution{{
+			Parent:     fce,
+			Resolution: &types.V2FileContractExpiration{},
+		}},
+	}
+	if _, err := cm.AddV2PoolTransactions(au.State.Index, []types.V2Transaction{resolution}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	if !ms.state.BurnedSupply.Equals(burn) {
+		t.Fatalf("expected burned supply %v, got %v", burn, ms.state.BurnedSupply)
+	} else if !ms.state.ContractBurnedSupply.Equals(burn) {
+		t.Fatalf("expected contract burned supply %v, got %v", burn, ms.state.ContractBurnedSupply)
+	} else if !ms.state.VoidBurnedSupply.IsZero() {
+		t.Fatalf("expected no void burned supply, got %v", ms.state.VoidBurnedSupply)
+	}
+
+	// revert the expiration
+	reorgChain(t, cm, contractHeight)
+	syncStore(t, ms, cm, 10)
+	if !ms.state.BurnedSupply.IsZero() || !ms.state.ContractBurnedSupply.IsZero() {
+		t.Fatalf("expected no burned supply, got %v", ms.state.BurnedSupply)
+	}
+
+	// the reverted state should match a fresh sync
+	fresh := newMemStore()
+	syncStore(t, fresh, cm, 10)
+	if ms.state != fresh.state {
+		t.Fatalf("expected state %+v, got %+v", fresh.state, ms.state)
+	}
+}
+
+func TestRevertFoundationUpdate(t *testing.T) {
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	primary, failsafe := uc.UnlockHash(), types.Address(frand.Entropy256())
+	cm := newFoundationTestChain(t, primary, failsafe)
+
+	// mine the initial foundation subsidy and wait for it to mature
+	genesisState := cm.TipState()
+	subsidy, ok := genesisState.FoundationSubsidy()
+	if !ok {
+		t.Fatal("expected foundation subsidy")
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	subsidyID := cm.Tip().ID.FoundationOutputID()
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(cm.TipState().Network.MaturityDelay))
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	initial := map[types.Address]FoundationRole{
+		primary:  FoundationRolePrimary,
+		failsafe: FoundationRoleFailsafe,
+	}
+	checkFoundation := func(expected map[types.Address]FoundationRole) {
+		t.Helper()
+		if len(ms.foundation) != len(expected) {
+			t.Fatalf("expected %d foundation addresses, got %d", len(expected), len(ms.foundation))
+		}
+		for addr, role := range expected {
+			if ms.foundation[addr] != role {
+				t.Fatalf("expected %v to have role %q, got %q", addr, role, ms.foundation[addr])
+			}
+		}
+	}
+	checkFoundation(initial)
+
+	// change the foundation address. The update must spend an output
+	// controlled by the current primary address.
+	update := types.FoundationAddressUpdate{
+		NewPrimary:  frand.Entropy256(),
+		NewFailsafe: frand.Entropy256(),
+	}
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	update.EncodeTo(e)
+	e.Flush()
+
+	cs := cm.TipState()
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         subsidyID,
+			UnlockConditions: uc,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: primary, Value: subsidy.Value}},
+		ArbitraryData:  [][]byte{append(types.SpecifierFoundation[:], buf.Bytes()...)},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(subsidyID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	sig := sk.SignHash(cs.WholeSigHash(txn, types.Hash256(subsidyID), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	updateHeight := cm.Tip().Height
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	if cm.TipState().FoundationSubsidyAddress != update.NewPrimary {
+		t.Fatal("expected foundation address to be updated")
+	}
+
+	// previous foundation addresses are still tracked
+	syncStore(t, ms, cm, 10)
+	checkFoundation(map[types.Address]FoundationRole{
+		primary:            FoundationRolePrimary,
+		failsafe:           FoundationRoleFailsafe,
+		update.NewPrimary:  FoundationRolePrimary,
+		update.NewFailsafe: FoundationRoleFailsafe,
+	})
+
+	// revert the update
+	reorgChain(t, cm, updateHeight)
+	syncStore(t, ms, cm, 10)
+	checkFoundation(initial)
+}
+
+func TestV2FoundationUpdate(t *testing.T) {
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	primary, failsafe := types.Address(frand.Entropy256()), policy.Address()
+	cm := newV2TestChain(t, primary, failsafe)
+
+	// v2 updates must spend an output controlled by the management
+	// address, which is initially the failsafe address
+	basis, sce := minerPayout(t, cm, failsafe)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	initial := map[types.Address]FoundationRole{
+		primary:  FoundationRolePrimary,
+		failsafe: FoundationRoleFailsafe,
+	}
+	checkFoundation := func(expected map[types.Address]FoundationRole) {
+		t.Helper()
+		if len(ms.foundation) != len(expected) {
+			t.Fatalf("expected %d foundation addresses, got %d", len(expected), len(ms.foundation))
+		}
+		for addr, role := range expected {
+			if ms.foundation[addr] != role {
+				t.Fatalf("expected %v to have role %q, got %q", addr, role, ms.foundation[addr])
+			}
+		}
+	}
+	checkFoundation(initial)
+
+	newAddr := types.Address(frand.Entropy256())
+	cs := cm.TipState()
+	txn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          sce,
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy},
+		}},
+		SiacoinOutputs:       []types.SiacoinOutput{sce.SiacoinOutput},
+		NewFoundationAddress: &newAddr,
+	}
+	txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+	if _, err := cm.AddV2PoolTransactions(basis, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	updateHeight := cm.Tip().Height
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	if cs := cm.TipState(); cs.FoundationSubsidyAddress != newAddr || cs.FoundationManagementAddress != newAddr {
+		t.Fatal("expected foundation address to be updated")
+	}
+
+	// previous foundation addresses are still tracked
+	syncStore(t, ms, cm, 10)
+	checkFoundation(map[types.Address]FoundationRole{
+		primary:  FoundationRolePrimary,
+		failsafe: FoundationRoleFailsafe,
+		newAddr:  FoundationRolePrimary,
+	})
+
+	// revert the update
+	reorgChain(t, cm, updateHeight)
+	syncStore(t, ms, cm, 10)
+	checkFoundation(initial)
+}
+
+func TestRewind(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	addr := uc.UnlockHash()
+
+	testutil.MineBlocks(t, cm, addr, 1)
+	payoutHeight := cm.Tip().Height
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(cm.TipState().Network.MaturityDelay))
+
+	// burn half of the miner payout
+	index, _ := cm.BestIndex(payoutHeight)
+	b, _ := cm.Block(index.ID)
+	parentID := index.ID.MinerOutputID(0)
+	payout := b.MinerPayouts[0].Value
+	burn := payout.Div64(2)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         parentID,
+			UnlockConditions: uc,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: burn},
+			{Address: frand.Entropy256(), Value: payout.Sub(burn)},
+		},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(parentID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	cs := cm.TipState()
+	sig := sk.SignHash(cs.WholeSigHash(txn, types.Hash256(parentID), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	burnHeight := cm.Tip().Height + 1
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	// syncTo syncs ms one block at a time until it reaches height
+	syncTo := func(ms *memStore, height uint64) {
+		t.Helper()
+		for {
+			reverted, applied, err := cm.UpdatesSince(ms.state.Index, 1)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := applyUpdates(ms, ms.state, reverted, applied[:1], log); err != nil {
+				t.Fatal(err)
+			} else if ms.state.Index.Height >= height {
+				return
+			}
+		}
+	}
+
+	// checkRewind rewinds a synced store to before from and compares it
+	// to a store synced directly to from-1
+	checkRewind := func(from uint64) {
+		t.Helper()
+		ms := newMemStore()
+		syncStore(t, ms, cm, 100)
+		if err := rewind(ms, managerClient{cm}, from, 3, log); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := newMemStore()
+		if from > 0 {
+			syncTo(expected, from-1)
+		}
+		if ms.state != expected.state {
+			t.Fatalf("rewind to %d: expected state %+v, got %+v", from, expected.state, ms.state)
+		}
+		for addr, balance := range ms.balances {
+			if !balance.Equals(expected.balances[addr]) {
+				t.Fatalf("rewind to %d: expected balance %v for %v, got %v", from, expected.balances[addr], addr, balance)
+			}
+		}
+		for addr, balance := range expected.balances {
+			if !balance.Equals(ms.balances[addr]) {
+				t.Fatalf("rewind to %d: expected balance %v for %v, got %v", from, balance, addr, ms.balances[addr])
+			}
+		}
+		for addr := range ms.foundation {
+			if _, ok := expected.foundation[addr]; !ok {
+				t.Fatalf("rewind to %d: unexpected foundation address %v", from, addr)
+			}
+		}
+		if len(ms.foundation) != len(expected.foundation) {
+			t.Fatalf("rewind to %d: expected %d foundation addresses, got %d", from, len(expected.foundation), len(ms.foundation))
+		}
+		if !ms.locked().Equals(expected.locked()) {
+			t.Fatalf("rewind to %d: expected locked supply %v, got %v", from, expected.locked(), ms.locked())
+		}
+	}
+	checkRewind(burnHeight)
+	checkRewind(burnHeight + 1)
+	checkRewind(1)
+	checkRewind(0)
+
+	// rewinding above the index is a no-op
+	ms := newMemStore()
+	syncStore(t, ms, cm, 100)
+	synced := ms.state
+	if !synced.VoidBurnedSupply.Equals(burn) || !synced.ContractBurnedSupply.IsZero() {
+		t.Fatalf("expected void burned supply %v and no contract burns, got %v and %v", burn, synced.VoidBurnedSupply, synced.ContractBurnedSupply)
+	}
+	if err := rewind(ms, managerClient{cm}, cm.Tip().Height+1, 3, log); err != nil {
+		t.Fatal(err)
+	} else if ms.state != synced {
+		t.Fatal("expected state to be unchanged")
+	}
+
+	// reindex while the indexer is running
+	r := NewReindexer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithPollInterval(10*time.Millisecond), WithReindexer(r))
+	}()
+	if err := r.Reindex(ctx, burnHeight); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if state, _ := ms.State(); state.Index == cm.Tip() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state, _ := ms.State(); state != synced {
+		t.Fatalf("expected state %+v after reindex, got %+v", synced, state)
+	}
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestLockedSupply(t *testing.T) {
+	cm := newTestChain(t)
+	ms := newMemStore()
+
+	// checkLocked compares the locked supply to the miner payouts and
+	// foundation subsidies of the blocks that have not matured, and the
+	// immature supply to their emission
+	checkLocked := func() {
+		t.Helper()
+		var expected, emission types.Currency
+		tip := cm.Tip().Height
+		delay := cm.TipState().Network.MaturityDelay
+		for height := tip; height > 0 && height+delay > tip; height-- {
+			index, _ := cm.BestIndex(height)
+			b, _ := cm.Block(index.ID)
+			for _, sco := range b.MinerPayouts {
+				expected = expected.Add(sco.Value)
+			}
+			parent, _ := cm.State(b.ParentID)
+			emission = emission.Add(parent.BlockReward())
+			if subsidy, ok := parent.FoundationSubsidy(); ok {
+				expected = expected.Add(subsidy.Value)
+				emission = emission.Add(subsidy.Value)
+			}
+		}
+		if locked := ms.locked(); !locked.Equals(expected) {
+			t.Fatalf("height %d: expected locked supply %v, got %v", tip, expected, locked)
+		} else if !ms.state.ImmatureSupply.Equals(emission) {
+			t.Fatalf("height %d: expected immature supply %v, got %v", tip, emission, ms.state.ImmatureSupply)
+		}
+	}
+
+	// the first payouts mature after MaturityDelay blocks
+	for i := 0; i < int(cm.TipState().Network.MaturityDelay)+2; i++ {
+		testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+		syncStore(t, ms, cm, 10)
+		checkLocked()
+	}
+
+	// reverted payouts are no longer locked
+	reorgChain(t, cm, cm.Tip().Height-3)
+	syncStore(t, ms, cm, 10)
+	checkLocked()
+}
+
+func TestInconsistentSupply(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 100)
+	synced := ms.state
+
+	// corrupt the indexed state so the next block is inconsistent
+	state := synced
+	state.CirculatingSupply = state.TotalSupply.Add(types.Siacoins(1))
+
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	reverted, applied, err := cm.UpdatesSince(synced.Index, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyUpdates(ms, state, reverted, applied, log); !errors.Is(err, ErrInconsistentSupply) {
+		t.Fatalf("expected ErrInconsistentSupply, got %v", err)
+	} else if ms.state != synced {
+		t.Fatal("expected inconsistent updates not to be committed")
+	}
+}
+
+func TestDeepReorg(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	const batchSize = 3
+	ms := newMemStore()
+	syncStore(t, ms, cm, batchSize)
+
+	// revert more blocks than fit in a single batch
+	reorgChain(t, cm, 2)
+	reverted, applied, err := consensusUpdates(managerClient{cm}, ms.state.Index, batchSize)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reverted) != 8 {
+		t.Fatalf("expected 8 reverted blocks, got %d", len(reverted))
+	} else if len(applied) == 0 {
+		t.Fatal("expected applied blocks")
+	} else if err := applyUpdates(ms, ms.state, reverted, applied, log); err != nil {
+		t.Fatal(err)
+	}
+
+	// the committed state should be on the new best chain
+	if index, ok := cm.BestIndex(ms.state.Index.Height); !ok || index != ms.state.Index {
+		t.Fatalf("expected index %v to be on the best chain", ms.state.Index)
+	}
+
+	syncStore(t, ms, cm, batchSize)
+	expected := newMemStore()
+	syncStore(t, expected, cm, 100)
+	if ms.state != expected.state {
+		t.Fatalf("expected state %+v, got %+v", expected.state, ms.state)
+	}
+	for addr, balance := range expected.balances {
+		if !balance.Equals(ms.balances[addr]) {
+			t.Fatalf("expected balance %v for %v, got %v", balance, addr, ms.balances[addr])
+		}
+	}
+	for addr, balance := range ms.balances {
+		if !balance.Equals(expected.balances[addr]) {
+			t.Fatalf("expected balance %v for %v, got %v", expected.balances[addr], addr, balance)
+		}
+	}
+}
+
+func TestFoundationSubsidy(t *testing.T) {
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 100)
+
+	// the subsidy is only minted at the hardfork height and then once per
+	// subsidy interval
+	initial := ms.state.FoundationSubsidy
+	if initial.IsZero() {
+		t.Fatal("expected foundation subsidy to be minted")
+	}
+
+	// revert the subsidy blocks and check that the replacement chain
+	// matches a fresh sync
+	reorgChain(t, cm, 0)
+	syncStore(t, ms, cm, 100)
+	expected := newMemStore()
+	syncStore(t, expected, cm, 100)
+	if !ms.state.FoundationSubsidy.Equals(expected.state.FoundationSubsidy) {
+		t.Fatalf("expected foundation subsidy %v, got %v", expected.state.FoundationSubsidy, ms.state.FoundationSubsidy)
+	} else if ms.state != expected.state {
+		t.Fatalf("expected state %+v, got %+v", expected.state, ms.state)
+	}
+}
+
+func TestEmissionBySource(t *testing.T) {
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	genesisIndex, _ := cm.BestIndex(0)
+	genesis, _ := cm.Block(genesisIndex.ID)
+	var genesisSupply types.Currency
+	for _, txn := range genesis.Transactions {
+		for _, sco := range txn.SiacoinOutputs {
+			genesisSupply = genesisSupply.Add(sco.Value)
+		}
+	}
+
+	// nothing is burned, so the total supply should be the sum of the
+	// genesis outputs and each source of emission
+	checkEmission := func(ms *memStore) {
+		t.Helper()
+		if ms.state.BlockRewardSupply.IsZero() {
+			t.Fatal("expected block rewards to be minted")
+		}
+		emitted := genesisSupply.Add(ms.state.BlockRewardSupply).Add(ms.state.FoundationSubsidy)
+		if !emitted.Equals(ms.state.TotalSupply) {
+			t.Fatalf("expected total supply %v, got %v", emitted, ms.state.TotalSupply)
+		}
+	}
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 100)
+	checkEmission(ms)
+
+	reorgChain(t, cm, 3)
+	syncStore(t, ms, cm, 100)
+	checkEmission(ms)
+}
+
+func TestGenesisSupply(t *testing.T) {
+	tests := []struct {
+		network func() (*consensus.Network, types.Block)
+		supply  types.Currency
+	}{
+		{chain.Mainnet, types.ZeroCurrency},
+		{chain.TestnetZen, types.Siacoins(1).Mul64(1e12)},
+		{chain.TestnetAnagami, types.Siacoins(1).Mul64(1e12)},
+	}
+	for _, test := range tests {
+		n, genesisBlock := test.network()
+		t.Run(n.Name, func(t *testing.T) {
+			store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesisBlock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cm := chain.NewManager(store, tipState)
+
+			ms := newMemStore()
+			syncStore(t, ms, cm, 1)
+			if !ms.state.TotalSupply.Equals(test.supply) {
+				t.Fatalf("expected total supply %v, got %v", test.supply, ms.state.TotalSupply)
+			} else if !ms.state.CirculatingSupply.Equals(test.supply) {
+				t.Fatalf("expected circulating supply %v, got %v", test.supply, ms.state.CirculatingSupply)
+			} else if _, ok := ms.foundation[types.VoidAddress]; ok {
+				t.Fatal("void address should not be a foundation address")
+			} else if role, ok := ms.foundation[n.HardforkFoundation.PrimaryAddress]; !ok || role != FoundationRolePrimary {
+				t.Fatal("expected primary foundation address to be set")
+			}
+		})
+	}
+}
+
+func TestRevertGenesis(t *testing.T) {
+	n, genesisBlock := testutil.Network()
+	n.HardforkFoundation.PrimaryAddress = frand.Entropy256()
+	n.HardforkFoundation.FailsafeAddress = frand.Entropy256()
+	// fund an address at genesis so the initial supply is not zero
+	genesisBlock.Transactions = append(genesisBlock.Transactions, types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: frand.Entropy256(), Value: types.Siacoins(1000)}},
+	})
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, tipState)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(n.MaturityDelay)+5)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	if ms.state.TotalSupply.IsZero() || len(ms.foundation) != 2 {
+		t.Fatal("expected the chain to be indexed")
+	}
+
+	// chain.Manager never reverts the genesis block, so build the revert
+	// updates from the tip to genesis directly
+	var reverted []chain.RevertUpdate
+	for id := cm.Tip().ID; ; {
+		b, bs, ok := store.Block(id)
+		if !ok {
+			t.Fatalf("missing block %v", id)
+		}
+		parent := n.GenesisState()
+		if b.ParentID != (types.BlockID{}) {
+			if parent, ok = store.State(b.ParentID); !ok {
+				t.Fatalf("missing state %v", b.ParentID)
+			}
+		}
+		reverted = append(reverted, chain.RevertUpdate{
+			RevertUpdate: consensus.RevertBlock(parent, b, *bs),
+			Block:        b,
+			State:        parent,
+		})
+		if b.ParentID == (types.BlockID{}) {
+			break
+		}
+		id = b.ParentID
+	}
+	if err := applyUpdates(ms, ms.state, reverted, nil, zaptest.NewLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ms.state != (State{}) {
+		t.Fatalf("expected empty state, got %+v", ms.state)
+	} else if len(ms.foundation) != 0 {
+		t.Fatalf("expected no foundation addresses, got %v", ms.foundation)
+	} else if len(ms.immature) != 0 {
+		t.Fatalf("expected no immature outputs, got %v", ms.immature)
+	}
+	for addr, balance := range ms.balances {
+		if !balance.IsZero() {
+			t.Fatalf("expected %v to be empty, got %v", addr, balance)
+		}
+	}
+
+	// the chain can be indexed again from genesis
+	syncStore(t, ms, cm, 10)
+	if ms.state.Index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), ms.state.Index)
+	}
+}
+
+func TestSiafundClaims(t *testing.T) {
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	addr := uc.UnlockHash()
+
+	// give all of the genesis siafunds to addr
+	n, genesisBlock := testutil.Network()
+	n.HardforkFoundation.PrimaryAddress = frand.Entropy256()
+	n.HardforkFoundation.FailsafeAddress = frand.Entropy256()
+	for i := range genesisBlock.Transactions[0].SiafundOutputs {
+		genesisBlock.Transactions[0].SiafundOutputs[i].Address = addr
+	}
+	store, tipState, err := chain.NewDBStore(chain.NewMemDB(), n, genesisBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, tipState)
+
+	// form a contract to add to the siafund pool
+	testutil.MineBlocks(t, cm, addr, 1)
+	payoutHeight := cm.Tip().Height
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(n.MaturityDelay))
+	index, _ := cm.BestIndex(payoutHeight)
+	b, _ := cm.Block(index.ID)
+	parentID := index.ID.MinerOutputID(0)
+	cs := cm.TipState()
+	fc := types.FileContract{
+		WindowStart: cs.Index.Height + 10,
+		WindowEnd:   cs.Index.Height + 20,
+		Payout:      b.MinerPayouts[0].Value,
+	}
+	fc.ValidProofOutputs = []types.SiacoinOutput{{Address: addr, Value: fc.Payout.Sub(cs.FileContractTax(fc))}}
+	fc.MissedProofOutputs = fc.ValidProofOutputs
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: parentID, UnlockConditions: uc}},
+		FileContracts: []types.FileContract{fc},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(parentID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	sig := sk.SignHash(cs.WholeSigHash(txn, types.Hash256(parentID), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+
+	// spend the siafunds to claim the pool
+	cs = cm.TipState()
+	if cs.SiafundTaxRevenue.IsZero() {
+		t.Fatal("expected siafund pool to be funded")
+	}
+	claimTxn := types.Transaction{
+		SiafundOutputs: genesisBlock.Transactions[0].SiafundOutputs,
+	}
+	var expected types.Currency
+	for i, sfo := range genesisBlock.Transactions[0].SiafundOutputs {
+		id := genesisBlock.Transactions[0].SiafundOutputID(i)
+		claimTxn.SiafundInputs = append(claimTxn.SiafundInputs, types.SiafundInput{
+			ParentID:         id,
+			UnlockConditions: uc,
+			ClaimAddress:     frand.Entropy256(),
+		})
+		claimTxn.Signatures = append(claimTxn.Signatures, types.TransactionSignature{
+			ParentID:      types.Hash256(id),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		})
+		expected = expected.Add(cs.SiafundTaxRevenue.Div64(cs.SiafundCount()).Mul64(sfo.Value))
+	}
+	for i := range claimTxn.Signatures {
+		sig := sk.SignHash(cs.WholeSigHash(claimTxn, claimTxn.Signatures[i].ParentID, 0, 0, nil))
+		claimTxn.Signatures[i].Signature = sig[:]
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{claimTxn}); err != nil {
+		t.Fatal(err)
+	}
+	claimHeight := cm.Tip().Height + 1
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 2)
+
+	ms := newMemStore()
+	syncStore(t, ms, cm, 10)
+	if !ms.state.SiafundClaims.Equals(expected) {
+		t.Fatalf("expected siafund claims %v, got %v", expected, ms.state.SiafundClaims)
+	}
+
+	// rewinding past the claim undoes it
+	if err := rewind(ms, managerClient{cm}, claimHeight, 10, zaptest.NewLogger(t)); err != nil {
+		t.Fatal(err)
+	} else if !ms.state.SiafundClaims.IsZero() {
+		t.Fatalf("expected no siafund claims after rewind, got %v", ms.state.SiafundClaims)
+	}
+	syncStore(t, ms, cm, 10)
+
+	// reverting the claim also undoes it
+	reorgChain(t, cm, claimHeight-1)
+	syncStore(t, ms, cm, 10)
+	if !ms.state.SiafundClaims.IsZero() {
+		t.Fatalf("expected no siafund claims after reorg, got %v", ms.state.SiafundClaims)
+	}
+}
+
+func TestStallMonitor(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	ms := newMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor := NewStallMonitor(50 * time.Millisecond)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithPollInterval(10*time.Millisecond), WithStallMonitor(monitor))
+	}()
+
+	waitForStalled := func(want bool) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			if stalled, _ := monitor.Stalled(); stalled == want {
+				return
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("expected stalled to be %v", want)
+			}
+		}
+	}
+
+	// the chain stops advancing once it is indexed
+	waitForStalled(true)
+
+	// a new block clears the stall
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	waitForStalled(false)
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestReorgCounter(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	cm := newTestChain(t)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 10)
+
+	ms := newMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := NewReorgCounter()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, ms, managerClient{cm}, log, WithPollInterval(10*time.Millisecond), WithReorgCounter(counter))
+	}()
+
+	waitForCounts := func(applied, reverted, maxDepth uint64) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			a, r, d := counter.Counts()
+			if a == applied && r == reverted && d == maxDepth {
+				return
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("expected counts (%d, %d, %d), got (%d, %d, %d)", applied, reverted, maxDepth, a, r, d)
+			}
+		}
+	}
+
+	// the genesis block and 10 mined blocks are applied
+	waitForCounts(11, 0, 0)
+
+	// a fork at height 7 reverts 3 blocks and applies 4
+	reorgChain(t, cm, 7)
+	waitForCounts(15, 3, 3)
+
+	// a shallower reorg does not lower the maximum depth
+	reorgChain(t, cm, 10)
+	waitForCounts(17, 4, 3)
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// the server accepts requests but never responds
+	reqs := make(chan struct{}, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case reqs <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	client := wapi.NewClient(srv.URL, "")
+
+	waitForRequest := func() {
+		t.Helper()
+		select {
+		case <-reqs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no request received")
+		}
+	}
+
+	// a hung request is abandoned after the timeout
+	tc := &timeoutClient{client: client, ctx: context.Background(), timeout: 50 * time.Millisecond}
+	if _, err := tc.ConsensusTip(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	waitForRequest()
+
+	// the indexer retries after a timeout and stops promptly when canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateConsensusState(ctx, newMemStore(), client, zaptest.NewLogger(t), WithRequestTimeout(50*time.Millisecond))
+	}()
+	waitForRequest()
+	waitForRequest()
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("indexer did not stop after the context was canceled")
+	}
+}
@@ -0,0 +1,155 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// ErrNotBestChain is returned when the index cannot be rewound because it is
+// not on walletd's best chain. The rewind can be retried once the index has
+// synced.
+var ErrNotBestChain = errors.New("index is not on the best chain")
+
+type (
+	// A Reindexer requests that a running indexer rewind the index and
+	// reapply blocks.
+	Reindexer struct {
+		reqs chan reindexRequest
+	}
+
+	reindexRequest struct {
+		from  uint64
+		errCh chan error
+	}
+)
+
+// Reindex rewinds the index so that all blocks starting at height from are
+// reapplied. Reindex blocks until the index has been rewound. If the index is
+// already below from, Reindex does nothing.
+func (r *Reindexer) Reindex(ctx context.Context, from uint64) error {
+	req := reindexRequest{
+		from:  from,
+		errCh: make(chan error, 1),
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.reqs <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-req.errCh:
+		return err
+	}
+}
+
+// NewReindexer returns a new Reindexer. It must be passed to
+// UpdateConsensusState using WithReindexer to take effect.
+func NewReindexer() *Reindexer {
+	return &Reindexer{
+		reqs: make(chan reindexRequest),
+	}
+}
+
+// rewind reverts the index to the state before the block at height from was
+// applied. walletd does not provide revert updates for blocks on the best
+// chain, so the blocks are requested as apply updates and their changes are
+// undone using the same accounting as a reorg.
+func rewind(store Store, client ChainClient, from uint64, batchSize int, log *zap.Logger) error {
+	state, err := store.State()
+	if err != nil {
+		return fmt.Errorf("failed to get state: %w", err)
+	} else if state.Index == (types.ChainIndex{}) || from > state.Index.Height {
+		return nil // nothing to rewind
+	}
+
+	// determine the state after the block at from-1 was applied. If from is
+	// 0, the index is reset to its initial state.
+	var target State
+	var parent types.ChainIndex
+	foundation := make(map[types.Address]bool)
+	if from > 0 {
+		if from > 1 {
+			parent, err = client.ConsensusIndex(from - 2)
+			if err != nil {
+				return fmt.Errorf("failed to get index at height %d: %w", from-2, err)
+			}
+		}
+		_, applied, err := client.ConsensusUpdates(parent, 1)
+		if err != nil {
+			return fmt.Errorf("failed to get consensus updates: %w", err)
+		} else if len(applied) == 0 {
+			return fmt.Errorf("missing block at height %d", from-1)
+		}
+		cau := applied[0]
+		target = State{
+			Index:       cau.State.Index,
+			Timestamp:   cau.Block.Timestamp,
+			SiafundPool: cau.State.SiafundTaxRevenue,
+		}
+		foundation[cau.State.FoundationSubsidyAddress] = true
+		foundation[cau.State.FoundationManagementAddress] = true
+		parent = cau.State.Index
+	}
+
+	// undo each block between the target and the current index. The
+	// changes are summed and reverted at once, since the intermediate sums
+	// are not states the index was ever in.
+	var change supplyChange
+	balances := newBalanceDeltas()
+	var removedFoundationAddresses []types.Address
+	for parent != state.Index {
+		reverted, applied, err := client.ConsensusUpdates(parent, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to get consensus updates: %w", err)
+		} else if len(reverted) != 0 || len(applied) == 0 {
+			return ErrNotBestChain
+		}
+
+		for _, cau := range applied {
+			if cau.State.Index.Height > state.Index.Height {
+				return ErrNotBestChain
+			}
+
+			parentState := cau.State
+			parentState.Index.Height--
+			d, err := newBlockDelta(parentState, cau.Block, cau)
+			if err != nil {
+				return err
+			}
+			change.add(d.supplyChange)
+			balances.add(d, true)
+			for _, fa := range d.foundation {
+				if !foundation[fa.Address] {
+					removedFoundationAddresses = append(removedFoundationAddresses, fa.Address)
+				}
+			}
+
+			parent = cau.State.Index
+			if parent == state.Index {
+				break
+			}
+		}
+	}
+
+	rewound := state
+	if err := change.revert(&rewound); err != nil {
+		return err
+	}
+	rewound.Index = target.Index
+	rewound.Timestamp = target.Timestamp
+	rewound.SiafundPool = target.SiafundPool
+
+	deltas, maturing := balances.deltas()
+	if err := store.UpdateState(rewound, nil, deltas, maturing, nil, removedFoundationAddresses); err != nil {
+		return fmt.Errorf("failed to update state: %w", err)
+	}
+	log.Info("rewound index", zap.Stringer("from", state.Index), zap.Stringer("to", rewound.Index))
+	return nil
+}
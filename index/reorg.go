@@ -0,0 +1,38 @@
+package index
+
+import "sync"
+
+// A ReorgCounter counts the blocks applied and reverted by the indexer and
+// the deepest reorg it has observed. The counts are kept in memory, so they
+// start at zero each time the indexer starts.
+type ReorgCounter struct {
+	mu       sync.Mutex
+	applied  uint64
+	reverted uint64
+	maxDepth uint64
+}
+
+// Counts returns the number of blocks applied and reverted and the maximum
+// number of blocks reverted by a single reorg.
+func (c *ReorgCounter) Counts() (applied, reverted, maxDepth uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.applied, c.reverted, c.maxDepth
+}
+
+// record adds a batch of updates to the counts. A deep reorg is requested
+// until the first applied block, so all of its reverted blocks are in the
+// same batch.
+func (c *ReorgCounter) record(reverted, applied int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applied += uint64(applied)
+	c.reverted += uint64(reverted)
+	c.maxDepth = max(c.maxDepth, uint64(reverted))
+}
+
+// NewReorgCounter returns a ReorgCounter. It must be passed to
+// UpdateConsensusState using WithReorgCounter to take effect.
+func NewReorgCounter() *ReorgCounter {
+	return &ReorgCounter{}
+}
@@ -0,0 +1,246 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	"go.sia.tech/walletd/api"
+	"go.uber.org/zap/zaptest"
+	"lukechampine.com/frand"
+)
+
+var updateFixtures = flag.Bool("update", false, "re-record the consensus update fixtures in testdata")
+
+// A recordedBatch is a consensus updates response recorded from walletd,
+// along with the index it was requested from.
+type recordedBatch struct {
+	Index types.ChainIndex `json:"index"`
+	api.ConsensusUpdatesResponse
+}
+
+// A replayExpectation is the indexed supply expected after replaying a
+// fixture.
+type replayExpectation struct {
+	Index              types.ChainIndex `json:"index"`
+	TotalSupply        types.Currency   `json:"totalSupply"`
+	CirculatingSupply  types.Currency   `json:"circulatingSupply"`
+	BurnedSupply       types.Currency   `json:"burnedSupply"`
+	FoundationTreasury types.Currency   `json:"foundationTreasury"`
+}
+
+// A replayFixture is a recorded sequence of consensus updates and the supply
+// expected after indexing them.
+type replayFixture struct {
+	Network  *consensus.Network `json:"network"`
+	Batches  []recordedBatch    `json:"batches"`
+	Expected replayExpectation  `json:"expected"`
+}
+
+// treasury returns the combined balance of the foundation addresses.
+func (ms *memStore) treasury() (treasury types.Currency) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for addr := range ms.foundation {
+		treasury = treasury.Add(ms.balances[addr])
+	}
+	return
+}
+
+// expectation returns the replay expectation for ms's current state.
+func (ms *memStore) expectation() replayExpectation {
+	return replayExpectation{
+		Index:              ms.state.Index,
+		TotalSupply:        ms.state.TotalSupply,
+		CirculatingSupply:  ms.state.CirculatingSupply,
+		BurnedSupply:       ms.state.BurnedSupply,
+		FoundationTreasury: ms.treasury(),
+	}
+}
+
+// replayBatches applies the recorded batches to ms in order. Each batch must
+// have been requested from ms's current index.
+func replayBatches(t *testing.T, ms *memStore, n *consensus.Network, batches []recordedBatch) {
+	t.Helper()
+
+	log := zaptest.NewLogger(t)
+	for i, batch := range batches {
+		if batch.Index != ms.state.Index {
+			t.Fatalf("batch %d: recorded from %v, but the index is at %v", i, batch.Index, ms.state.Index)
+		}
+
+		// the network is not included in the response, the walletd client
+		// sets it from the network endpoint
+		reverted := make([]chain.RevertUpdate, 0, len(batch.Reverted))
+		for _, u := range batch.Reverted {
+			u.State.Network = n
+			reverted = append(reverted, chain.RevertUpdate{RevertUpdate: u.Update, State: u.State, Block: u.Block})
+		}
+		applied := make([]chain.ApplyUpdate, 0, len(batch.Applied))
+		for _, u := range batch.Applied {
+			u.State.Network = n
+			applied = append(applied, chain.ApplyUpdate{ApplyUpdate: u.Update, State: u.State, Block: u.Block})
+		}
+		if err := applyUpdates(ms, ms.state, reverted, applied, log); err != nil {
+			t.Fatalf("batch %d: %v", i, err)
+		}
+	}
+}
+
+// recordBatches syncs ms with cm, recording each batch of consensus updates
+// in the format returned by walletd.
+func recordBatches(t *testing.T, ms *memStore, cm *chain.Manager, batchSize int) (batches []recordedBatch) {
+	t.Helper()
+
+	for ms.state.Index != cm.Tip() {
+		reverted, applied, err := cm.UpdatesSince(ms.state.Index, batchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := recordedBatch{Index: ms.state.Index}
+		for _, u := range reverted {
+			batch.Reverted = append(batch.Reverted, api.RevertUpdate{Update: u.RevertUpdate, State: u.State, Block: u.Block})
+		}
+		for _, u := range applied {
+			batch.Applied = append(batch.Applied, api.ApplyUpdate{Update: u.ApplyUpdate, State: u.State, Block: u.Block})
+		}
+		// replay the batch as it will be read from the fixture
+		replayBatches(t, ms, cm.TipState().Network, []recordedBatch{batch})
+		batches = append(batches, batch)
+	}
+	return
+}
+
+// recordReorgFixture mines a chain that spends and burns part of the
+// foundation subsidy, updates the foundation addresses, and reverts the
+// update with a reorg, recording the consensus updates indexed along the way.
+func recordReorgFixture(t *testing.T) replayFixture {
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	primary, failsafe := uc.UnlockHash(), types.Address(frand.Entropy256())
+	cm := newFoundationTestChain(t, primary, failsafe)
+	ms := newMemStore()
+
+	// signAndMine signs the transaction's only input with sk and mines it
+	signAndMine := func(txn types.Transaction) {
+		t.Helper()
+		parentID := txn.SiacoinInputs[0].ParentID
+		txn.Signatures = []types.TransactionSignature{{
+			ParentID:      types.Hash256(parentID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}}
+		sig := sk.SignHash(cm.TipState().WholeSigHash(txn, types.Hash256(parentID), 0, 0, nil))
+		txn.Signatures[0].Signature = sig[:]
+		if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+		testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	}
+
+	// mine the initial foundation subsidy and wait for it to mature
+	subsidy, ok := cm.TipState().FoundationSubsidy()
+	if !ok {
+		t.Fatal("expected foundation subsidy")
+	}
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	subsidyID := cm.Tip().ID.FoundationOutputID()
+	testutil.MineBlocks(t, cm, frand.Entropy256(), int(cm.TipState().Network.MaturityDelay))
+	batches := recordBatches(t, ms, cm, 3)
+
+	// burn part of the subsidy
+	burn := types.Siacoins(1000)
+	burnTxn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: subsidyID, UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: burn},
+			{Address: primary, Value: subsidy.Value.Sub(burn)},
+		},
+	}
+	signAndMine(burnTxn)
+	changeID := burnTxn.SiacoinOutputID(1)
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 2)
+	batches = append(batches, recordBatches(t, ms, cm, 3)...)
+
+	// update the foundation addresses
+	update := types.FoundationAddressUpdate{
+		NewPrimary:  frand.Entropy256(),
+		NewFailsafe: frand.Entropy256(),
+	}
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	update.EncodeTo(e)
+	e.Flush()
+	updateHeight := cm.Tip().Height
+	signAndMine(types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: changeID, UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: primary, Value: subsidy.Value.Sub(burn)}},
+		ArbitraryData:  [][]byte{append(types.SpecifierFoundation[:], buf.Bytes()...)},
+	})
+	testutil.MineBlocks(t, cm, frand.Entropy256(), 1)
+	batches = append(batches, recordBatches(t, ms, cm, 3)...)
+
+	// revert the update
+	reorgChain(t, cm, updateHeight)
+	batches = append(batches, recordBatches(t, ms, cm, 3)...)
+
+	// sanity check the recorded state against a fresh index of the final
+	// chain
+	fresh := newMemStore()
+	syncStore(t, fresh, cm, 10)
+	if ms.state != fresh.state {
+		t.Fatalf("expected state %+v, got %+v", fresh.state, ms.state)
+	} else if !fresh.treasury().Equals(subsidy.Value.Sub(burn)) {
+		t.Fatalf("expected treasury %v, got %v", subsidy.Value.Sub(burn), fresh.treasury())
+	}
+
+	return replayFixture{
+		Network:  cm.TipState().Network,
+		Batches:  batches,
+		Expected: ms.expectation(),
+	}
+}
+
+func TestReplayConsensusUpdates(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(*testing.T) replayFixture
+	}{
+		{"reorg", recordReorgFixture},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join("testdata", test.name+".json")
+			if *updateFixtures {
+				buf, err := json.MarshalIndent(test.record(t), "", "\t")
+				if err != nil {
+					t.Fatal(err)
+				} else if err := os.WriteFile(path, append(buf, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fixture replayFixture
+			if err := json.Unmarshal(buf, &fixture); err != nil {
+				t.Fatal(err)
+			}
+
+			ms := newMemStore()
+			replayBatches(t, ms, fixture.Network, fixture.Batches)
+			if got := ms.expectation(); got != fixture.Expected {
+				t.Fatalf("expected %+v, got %+v", fixture.Expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package index
+
+import (
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// DefaultStallThreshold is the default time without a new indexed block
+// before the chain is considered stalled. Blocks are mined every 10 minutes
+// on average.
+const DefaultStallThreshold = 30 * time.Minute
+
+// A StallMonitor tracks how long it has been since the index last advanced to
+// a new block. If the indexer is synced with walletd and the index stops
+// advancing, walletd's tip has stopped advancing too.
+type StallMonitor struct {
+	threshold time.Duration
+
+	mu          sync.Mutex
+	index       types.ChainIndex
+	lastAdvance time.Time
+	stalled     bool
+}
+
+// Stalled returns true if the index has not advanced for longer than the
+// stall threshold, along with the time since it last advanced.
+func (m *StallMonitor) Stalled() (bool, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	since := time.Since(m.lastAdvance)
+	return since > m.threshold, since
+}
+
+// observe records the current index. It returns true if the monitor changed
+// between stalled and not stalled since the last call, so each stall is only
+// logged once.
+func (m *StallMonitor) observe(index types.ChainIndex) (stalled, changed bool, since time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if index != m.index {
+		m.index = index
+		m.lastAdvance = time.Now()
+	}
+	since = time.Since(m.lastAdvance)
+	stalled = since > m.threshold
+	changed = stalled != m.stalled
+	m.stalled = stalled
+	return
+}
+
+// NewStallMonitor returns a StallMonitor that reports a stall once the index
+// has not advanced for longer than threshold. It must be passed to
+// UpdateConsensusState using WithStallMonitor to take effect.
+func NewStallMonitor(threshold time.Duration) *StallMonitor {
+	return &StallMonitor{
+		threshold:   threshold,
+		lastAdvance: time.Now(),
+	}
+}
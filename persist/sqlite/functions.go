@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"go.sia.tech/core/types"
+)
+
+// driverName is the name of the sqlite3 driver with the custom functions
+// registered.
+const driverName = "sqlite3_supply"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("apply_delta", applyDelta, true); err != nil {
+				return fmt.Errorf("failed to register apply_delta: %w", err)
+			}
+			return nil
+		},
+	})
+}
+
+// applyDelta is a SQL function that adds incoming to and subtracts outgoing
+// from an encoded balance. SQLite integers are 64 bits, so the 128-bit
+// currency arithmetic cannot be done with the built-in operators. It returns
+// an error if the balance would overflow or become negative.
+func applyDelta(balance, incoming, outgoing []byte) ([]byte, error) {
+	var b, in, out types.Currency
+	if err := decode(&b).Scan(balance); err != nil {
+		return nil, fmt.Errorf("failed to decode balance: %w", err)
+	} else if err := decode(&in).Scan(incoming); err != nil {
+		return nil, fmt.Errorf("failed to decode incoming: %w", err)
+	} else if err := decode(&out).Scan(outgoing); err != nil {
+		return nil, fmt.Errorf("failed to decode outgoing: %w", err)
+	}
+
+	b, overflow := b.AddWithOverflow(in)
+	if overflow {
+		return nil, fmt.Errorf("balance %v + %v overflows", b, in)
+	}
+	b, underflow := b.SubWithUnderflow(out)
+	if underflow {
+		return nil, fmt.Errorf("balance would be negative")
+	}
+	return encode(b).([]byte), nil
+}
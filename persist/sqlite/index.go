@@ -4,66 +4,80 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"go.sia.tech/cmc-supply-api/index"
 	"go.sia.tech/core/types"
 )
 
+// queries used by UpdateState. They are prepared once when the database is
+// opened.
 const (
-	pruneThreshold = 1000
+	removeFoundationAddressQuery = `UPDATE address_balances SET is_foundation=false, foundation_role=NULL WHERE address=$1`
+	// deleteEmptyAddressQuery removes an address that may have only been
+	// tracked because it was a foundation address
+	deleteEmptyAddressQuery      = `DELETE FROM address_balances WHERE address=$1 AND siacoin_balance=$2`
+	insertFoundationAddressQuery = `INSERT INTO address_balances (address, siacoin_balance, is_foundation, foundation_role) VALUES ($1, $2, true, $3) ON CONFLICT (address) DO UPDATE SET is_foundation=true, foundation_role=EXCLUDED.foundation_role`
+	// updateBalanceQuery computes the new balance in the database to avoid
+	// reading each address. WHERE true is required to disambiguate the
+	// upsert from a join.
+	updateBalanceQuery = `INSERT INTO address_balances (address, siacoin_balance)
+SELECT $1, apply_delta(COALESCE((SELECT siacoin_balance FROM address_balances WHERE address=$1), $2), $3, $4) WHERE true
+ON CONFLICT (address) DO UPDATE SET siacoin_balance=EXCLUDED.siacoin_balance`
+	updateImmatureQuery = `INSERT INTO immature_supply (maturity_height, siacoin_value)
+SELECT $1, apply_delta(COALESCE((SELECT siacoin_value FROM immature_supply WHERE maturity_height=$1), $2), $3, $4) WHERE true
+ON CONFLICT (maturity_height) DO UPDATE SET siacoin_value=EXCLUDED.siacoin_value`
+	insertHistoryQuery = `INSERT INTO supply_history (height, block_id, block_timestamp, total_supply, circulating_supply, burned_supply, siafund_pool, foundation_subsidy, block_reward_supply, siafund_claims, void_burned_supply, contract_burned_supply, immature_supply) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (height) DO UPDATE SET block_id=EXCLUDED.block_id, block_timestamp=EXCLUDED.block_timestamp, total_supply=EXCLUDED.total_supply, circulating_supply=EXCLUDED.circulating_supply, burned_supply=EXCLUDED.burned_supply, siafund_pool=EXCLUDED.siafund_pool, foundation_subsidy=EXCLUDED.foundation_subsidy, block_reward_supply=EXCLUDED.block_reward_supply, siafund_claims=EXCLUDED.siafund_claims, void_burned_supply=EXCLUDED.void_burned_supply, contract_burned_supply=EXCLUDED.contract_burned_supply, immature_supply=EXCLUDED.immature_supply`
 )
 
-type updateTxn struct {
-	tx *txn
-}
-
-func (s *Store) UpdateState(state index.State, addressDeltas []index.AddressDelta, foundationAddresses []types.Address) error {
+// UpdateState updates the indexed state. history contains the state after
+// each block that was applied. Any history above the new index is removed.
+func (s *Store) UpdateState(state index.State, history []index.State, addressDeltas []index.AddressDelta, maturityDeltas []index.MaturityDelta, foundationAddresses []index.FoundationAddress, removedFoundationAddresses []types.Address) error {
 	return s.transaction(func(tx *txn) error {
-		if len(foundationAddresses) > 0 {
-			insertAddressStmt, err := tx.Prepare(`INSERT INTO address_balances (address, siacoin_balance, is_foundation) VALUES ($1, $2, true) ON CONFLICT (address) DO UPDATE SET is_foundation=true`)
-			if err != nil {
-				return fmt.Errorf("failed to prepare statement: %w", err)
+		if len(removedFoundationAddresses) > 0 {
+			removeAddressStmt := tx.Stmt(s.stmts.removeFoundationAddress)
+			deleteEmptyStmt := tx.Stmt(s.stmts.deleteEmptyAddress)
+			for _, addr := range removedFoundationAddresses {
+				if _, err := removeAddressStmt.Exec(encode(addr)); err != nil {
+					return fmt.Errorf("failed to remove foundation address: %w", err)
+				} else if _, err := deleteEmptyStmt.Exec(encode(addr), encode(types.ZeroCurrency)); err != nil {
+					return fmt.Errorf("failed to delete empty address: %w", err)
+				}
 			}
-			defer insertAddressStmt.Close()
+		}
 
-			for _, addr := range foundationAddresses {
-				_, err = insertAddressStmt.Exec(encode(addr), encode(types.ZeroCurrency))
-				if err != nil {
+		if len(foundationAddresses) > 0 {
+			insertAddressStmt := tx.Stmt(s.stmts.insertFoundationAddress)
+			for _, fa := range foundationAddresses {
+				if _, err := insertAddressStmt.Exec(encode(fa.Address), encode(types.ZeroCurrency), fa.Role); err != nil {
 					return fmt.Errorf("failed to insert foundation address: %w", err)
 				}
 			}
 		}
 
 		if len(addressDeltas) != 0 {
-			selectStmt, err := tx.Prepare(`SELECT siacoin_balance FROM address_balances WHERE address=$1`)
-			if err != nil {
-				return fmt.Errorf("failed to prepare select statement: %w", err)
+			updateStmt := tx.Stmt(s.stmts.updateBalance)
+			for _, delta := range addressDeltas {
+				if _, err := updateStmt.Exec(encode(delta.Address), encode(types.ZeroCurrency), encode(delta.Incoming), encode(delta.Outgoing)); err != nil {
+					return fmt.Errorf("failed to update balance of %v: %w", delta.Address, err)
+				}
 			}
-			defer selectStmt.Close()
 
-			updateStmt, err := tx.Prepare(`INSERT INTO address_balances (address, siacoin_balance) VALUES ($1, $2) ON CONFLICT (address) DO UPDATE SET siacoin_balance=EXCLUDED.siacoin_balance`)
-			if err != nil {
-				return fmt.Errorf("failed to prepare update statement: %w", err)
+			// empty addresses are removed unless they are foundation addresses
+			if _, err := tx.Exec(`DELETE FROM address_balances WHERE siacoin_balance=$1 AND is_foundation=false`, encode(types.ZeroCurrency)); err != nil {
+				return fmt.Errorf("failed to delete empty addresses: %w", err)
 			}
-			defer updateStmt.Close()
+		}
 
-			for _, delta := range addressDeltas {
-				var balance types.Currency
-				err = selectStmt.QueryRow(encode(delta.Address)).Scan(decode(&balance))
-				if err != nil && !errors.Is(err, sql.ErrNoRows) {
-					return fmt.Errorf("failed to get current balance: %w", err)
-				}
-				balance = balance.Add(delta.Incoming).Sub(delta.Outgoing)
+		if err := updateImmatureSupply(tx, tx.Stmt(s.stmts.updateImmature), state, maturityDeltas); err != nil {
+			return fmt.Errorf("failed to update immature supply: %w", err)
+		}
 
-				if res, err := updateStmt.Exec(encode(delta.Address), encode(balance)); err != nil {
-					return fmt.Errorf("failed to update balance: %w", err)
-				} else if n, _ := res.RowsAffected(); n != 1 {
-					return errors.New("balance not updated")
-				}
-			}
+		if err := updateSupplyHistory(tx, tx.Stmt(s.stmts.insertHistory), state, history, s.historyRetention); err != nil {
+			return fmt.Errorf("failed to update supply history: %w", err)
 		}
 
-		_, err := tx.Exec(`UPDATE global_settings SET (total_supply, circulating_supply, burned_supply, last_indexed_height, last_indexed_id) = ($1, $2, $3, $4, $5)`, encode(state.TotalSupply), encode(state.CirculatingSupply), encode(state.BurnedSupply), state.Index.Height, encode(state.Index.ID))
+		_, err := tx.Exec(`UPDATE global_settings SET (total_supply, circulating_supply, burned_supply, siafund_pool, foundation_subsidy, block_reward_supply, siafund_claims, void_burned_supply, contract_burned_supply, immature_supply, last_indexed_height, last_indexed_id, last_indexed_timestamp) = ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`, encode(state.TotalSupply), encode(state.CirculatingSupply), encode(state.BurnedSupply), encode(state.SiafundPool), encode(state.FoundationSubsidy), encode(state.BlockRewardSupply), encode(state.SiafundClaims), encode(state.VoidBurnedSupply), encode(state.ContractBurnedSupply), encode(state.ImmatureSupply), state.Index.Height, encode(state.Index.ID), encode(state.Timestamp))
 		return err
 	})
 }
@@ -71,19 +85,139 @@ func (s *Store) UpdateState(state index.State, addressDeltas []index.AddressDelt
 // State returns the current state
 func (s *Store) State() (state index.State, err error) {
 	err = s.transaction(func(tx *txn) error {
-		return tx.QueryRow(`SELECT last_indexed_id, last_indexed_height, total_supply, circulating_supply, burned_supply FROM global_settings`).Scan(decode(&state.Index.ID), &state.Index.Height, decode(&state.TotalSupply), decode(&state.CirculatingSupply), decode(&state.BurnedSupply))
+		state, err = getState(tx)
+		return err
 	})
 	return
 }
 
-// FoundationTreasury returns the current value of the foundation treasury
+// Reset clears the indexed state in a single transaction so the chain is
+// indexed again from genesis. The schema and database version are kept.
+func (s *Store) Reset() error {
+	return s.transaction(resetIndex)
+}
+
+// FoundationTreasury returns the current value of the foundation treasury,
+// including both the primary and failsafe addresses.
 func (s *Store) FoundationTreasury() (value types.Currency, err error) {
 	err = s.transaction(func(tx *txn) error {
-		const query = `SELECT siacoin_balance FROM address_balances WHERE is_foundation=true`
+		value, err = foundationTreasury(tx)
+		return err
+	})
+	return
+}
+
+// FoundationAddresses returns the balances of the addresses marked as
+// foundation addresses, sorted by balance in descending order. Their sum is
+// the foundation treasury.
+func (s *Store) FoundationAddresses() (balances []index.AddressBalance, err error) {
+	err = s.transaction(func(tx *txn) error {
+		balances, err = queryBalances(tx, `SELECT address, siacoin_balance FROM address_balances WHERE is_foundation=true ORDER BY siacoin_balance DESC, address DESC`)
+		return err
+	})
+	return
+}
+
+// Supply returns the current state and the value of the foundation treasury.
+// Both are read in the same transaction so they are consistent with each
+// other.
+func (s *Store) Supply() (state index.State, treasury types.Currency, err error) {
+	err = s.transaction(func(tx *txn) error {
+		state, err = getState(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get state: %w", err)
+		}
+		treasury, err = foundationTreasury(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get foundation treasury: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// SupplyExcluding returns the current state and the combined value of the
+// foundation treasury and the balances of addrs. Foundation addresses in addrs
+// are only counted once. Unindexed addresses have no balance.
+func (s *Store) SupplyExcluding(addrs []types.Address) (state index.State, excluded types.Currency, err error) {
+	err = s.transaction(func(tx *txn) error {
+		state, err = getState(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get state: %w", err)
+		}
+		excluded, err = foundationTreasury(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get foundation treasury: %w", err)
+		}
+
+		if len(addrs) == 0 {
+			return nil
+		}
+		balanceStmt, err := tx.Prepare(`SELECT siacoin_balance FROM address_balances WHERE address=$1 AND is_foundation=false`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer balanceStmt.Close()
+
+		for _, addr := range addrs {
+			var balance types.Currency
+			err := balanceStmt.QueryRow(encode(addr)).Scan(decode(&balance))
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to get balance of %v: %w", addr, err)
+			}
+			excluded = excluded.Add(balance)
+		}
+		return nil
+	})
+	return
+}
+
+// LockedSupply returns the current state and the value of the immature
+// outputs that cannot be spent until a later block. Both are read in the same
+// transaction so they are consistent with each other.
+func (s *Store) LockedSupply() (state index.State, locked types.Currency, err error) {
+	err = s.transaction(func(tx *txn) error {
+		state, err = getState(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get state: %w", err)
+		}
+
+		// values are stored as blobs, so they can't be summed by SQLite
+		rows, err := tx.Query(`SELECT siacoin_value FROM immature_supply WHERE maturity_height > $1`, state.Index.Height)
+		if err != nil {
+			return fmt.Errorf("failed to query immature supply: %w", err)
+		}
+		defer rows.Close()
+
+		var value types.Currency
+		for rows.Next() {
+			if err := rows.Scan(decode(&value)); err != nil {
+				return fmt.Errorf("failed to scan immature supply: %w", err)
+			}
+			locked = locked.Add(value)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// Audit sums the balances of every indexed address and returns it with the
+// circulating supply at the same indexed height.
+func (s *Store) Audit() (audit index.Audit, err error) {
+	err = s.transaction(func(tx *txn) error {
+		state, err := getState(tx)
+		if err != nil {
+			return fmt.Errorf("failed to get state: %w", err)
+		}
+		audit.Index = state.Index
+		audit.CirculatingSupply = state.CirculatingSupply
 
-		rows, err := tx.Query(query)
+		// balances are stored as blobs, so they can't be summed by SQLite
+		rows, err := tx.Query(`SELECT siacoin_balance FROM address_balances`)
 		if err != nil {
-			return fmt.Errorf("failed to query foundation balance: %w", err)
+			return fmt.Errorf("failed to query balances: %w", err)
 		}
 		defer rows.Close()
 
@@ -92,9 +226,204 @@ func (s *Store) FoundationTreasury() (value types.Currency, err error) {
 			if err := rows.Scan(decode(&balance)); err != nil {
 				return fmt.Errorf("failed to scan balance: %w", err)
 			}
-			value = value.Add(balance)
+			audit.AddressBalances = audit.AddressBalances.Add(balance)
 		}
 		return rows.Err()
 	})
 	return
 }
+
+// RichList returns the addresses with the largest siacoin balances, sorted by
+// balance in descending order. Addresses with equal balances are sorted by
+// address in descending order.
+func (s *Store) RichList(limit, offset int) (balances []index.AddressBalance, err error) {
+	err = s.transaction(func(tx *txn) error {
+		balances, err = queryBalances(tx, `SELECT address, siacoin_balance FROM address_balances ORDER BY siacoin_balance DESC, address DESC LIMIT $1 OFFSET $2`, limit, offset)
+		return err
+	})
+	return
+}
+
+// RichListAfter returns up to limit addresses that follow the given balance
+// and address in the rich list. Unlike RichList, pages are stable when
+// balances change between requests.
+func (s *Store) RichListAfter(balance types.Currency, address types.Address, limit int) (balances []index.AddressBalance, err error) {
+	err = s.transaction(func(tx *txn) error {
+		balances, err = queryBalances(tx, `SELECT address, siacoin_balance FROM address_balances WHERE (siacoin_balance, address) < ($1, $2) ORDER BY siacoin_balance DESC, address DESC LIMIT $3`, encode(balance), encode(address), limit)
+		return err
+	})
+	return
+}
+
+// AddressBalance returns the siacoin balance of an address and whether it is a
+// foundation address. If the address is not indexed, index.ErrNotFound is
+// returned.
+func (s *Store) AddressBalance(addr types.Address) (balance types.Currency, foundation bool, err error) {
+	err = s.transaction(func(tx *txn) error {
+		err := tx.QueryRow(`SELECT siacoin_balance, is_foundation FROM address_balances WHERE address=$1`, encode(addr)).Scan(decode(&balance), &foundation)
+		if errors.Is(err, sql.ErrNoRows) {
+			return index.ErrNotFound
+		}
+		return err
+	})
+	return
+}
+
+// SupplyAtHeight returns the state after the block at the given height was
+// applied.
+func (s *Store) SupplyAtHeight(height uint64) (state index.State, err error) {
+	err = s.transaction(func(tx *txn) error {
+		const query = `SELECT height, block_id, block_timestamp, total_supply, circulating_supply, burned_supply, siafund_pool, foundation_subsidy, block_reward_supply, siafund_claims, void_burned_supply, contract_burned_supply, immature_supply FROM supply_history WHERE height=$1`
+		err := tx.QueryRow(query, height).Scan(&state.Index.Height, decode(&state.Index.ID), decode(&state.Timestamp), decode(&state.TotalSupply), decode(&state.CirculatingSupply), decode(&state.BurnedSupply), decode(&state.SiafundPool), decode(&state.FoundationSubsidy), decode(&state.BlockRewardSupply), decode(&state.SiafundClaims), decode(&state.VoidBurnedSupply), decode(&state.ContractBurnedSupply), decode(&state.ImmatureSupply))
+		if errors.Is(err, sql.ErrNoRows) {
+			return index.ErrNotFound
+		}
+		return err
+	})
+	return
+}
+
+// SupplyAtTime returns the state after the block with the latest timestamp at
+// or before t was applied. Block timestamps are not strictly increasing, so
+// ties are broken by the greatest height. If no block was mined at or before
+// t, index.ErrNotFound is returned.
+func (s *Store) SupplyAtTime(t time.Time) (state index.State, err error) {
+	err = s.transaction(func(tx *txn) error {
+		history, err := queryHistory(tx, `WHERE block_timestamp <= $1 ORDER BY block_timestamp DESC, height DESC LIMIT 1`, encode(t))
+		if err != nil {
+			return err
+		} else if len(history) == 0 {
+			return index.ErrNotFound
+		}
+		state = history[0]
+		return nil
+	})
+	return
+}
+
+// SupplyHistory returns up to limit states with heights in the range
+// [from, to], sorted by height.
+func (s *Store) SupplyHistory(from, to uint64, limit int) (history []index.State, err error) {
+	err = s.transaction(func(tx *txn) error {
+		history, err = queryHistory(tx, `WHERE height BETWEEN $1 AND $2 ORDER BY height ASC LIMIT $3`, from, to, limit)
+		return err
+	})
+	return
+}
+
+// SampledSupplyHistory is like SupplyHistory, but only returns the states at
+// every step blocks starting from the from height. Heights that have been
+// pruned or not indexed are skipped.
+func (s *Store) SampledSupplyHistory(from, to, step uint64, limit int) (history []index.State, err error) {
+	err = s.transaction(func(tx *txn) error {
+		history, err = queryHistory(tx, `WHERE height BETWEEN $1 AND $2 AND (height - $1) % $3 = 0 ORDER BY height ASC LIMIT $4`, from, to, step, limit)
+		return err
+	})
+	return
+}
+
+func updateSupplyHistory(tx *txn, insertStmt *stmt, state index.State, history []index.State, retention uint64) error {
+	// remove any reverted history. If the genesis block was reverted, the
+	// index is uninitialized and there is no history left.
+	query := `DELETE FROM supply_history WHERE height > $1`
+	if state.Index == (types.ChainIndex{}) {
+		query = `DELETE FROM supply_history WHERE height >= $1`
+	}
+	if _, err := tx.Exec(query, state.Index.Height); err != nil {
+		return fmt.Errorf("failed to delete reverted history: %w", err)
+	}
+
+	for _, h := range history {
+		if _, err := insertStmt.Exec(h.Index.Height, encode(h.Index.ID), encode(h.Timestamp), encode(h.TotalSupply), encode(h.CirculatingSupply), encode(h.BurnedSupply), encode(h.SiafundPool), encode(h.FoundationSubsidy), encode(h.BlockRewardSupply), encode(h.SiafundClaims), encode(h.VoidBurnedSupply), encode(h.ContractBurnedSupply), encode(h.ImmatureSupply)); err != nil {
+			return fmt.Errorf("failed to insert history at height %d: %w", h.Index.Height, err)
+		}
+	}
+
+	if retention > 0 && state.Index.Height > retention {
+		if _, err := tx.Exec(`DELETE FROM supply_history WHERE height < $1`, state.Index.Height-retention); err != nil {
+			return fmt.Errorf("failed to prune history: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateImmatureSupply applies the maturity deltas and removes outputs that
+// have matured. Matured outputs are kept for MinHistoryRetention blocks so a
+// reorg can still revert the blocks that created them.
+func updateImmatureSupply(tx *txn, updateStmt *stmt, state index.State, deltas []index.MaturityDelta) error {
+	for _, delta := range deltas {
+		if _, err := updateStmt.Exec(delta.MaturityHeight, encode(types.ZeroCurrency), encode(delta.Incoming), encode(delta.Outgoing)); err != nil {
+			return fmt.Errorf("failed to update immature supply at height %d: %w", delta.MaturityHeight, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM immature_supply WHERE siacoin_value=$1`, encode(types.ZeroCurrency)); err != nil {
+		return fmt.Errorf("failed to delete empty immature supply: %w", err)
+	} else if state.Index.Height > MinHistoryRetention {
+		if _, err := tx.Exec(`DELETE FROM immature_supply WHERE maturity_height < $1`, state.Index.Height-MinHistoryRetention); err != nil {
+			return fmt.Errorf("failed to prune matured supply: %w", err)
+		}
+	}
+	return nil
+}
+
+func queryBalances(tx *txn, query string, args ...any) (balances []index.AddressBalance, err error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query balances: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var balance index.AddressBalance
+		if err := rows.Scan(decode(&balance.Address), decode(&balance.Balance)); err != nil {
+			return nil, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		balances = append(balances, balance)
+	}
+	return balances, rows.Err()
+}
+
+// queryHistory returns the supply history matching the where clause and its
+// arguments.
+func queryHistory(tx *txn, where string, args ...any) ([]index.State, error) {
+	rows, err := tx.Query(`SELECT height, block_id, block_timestamp, total_supply, circulating_supply, burned_supply, siafund_pool, foundation_subsidy, block_reward_supply, siafund_claims, void_burned_supply, contract_burned_supply, immature_supply FROM supply_history `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []index.State
+	for rows.Next() {
+		var state index.State
+		if err := rows.Scan(&state.Index.Height, decode(&state.Index.ID), decode(&state.Timestamp), decode(&state.TotalSupply), decode(&state.CirculatingSupply), decode(&state.BurnedSupply), decode(&state.SiafundPool), decode(&state.FoundationSubsidy), decode(&state.BlockRewardSupply), decode(&state.SiafundClaims), decode(&state.VoidBurnedSupply), decode(&state.ContractBurnedSupply), decode(&state.ImmatureSupply)); err != nil {
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		history = append(history, state)
+	}
+	return history, rows.Err()
+}
+
+func getState(tx *txn) (state index.State, err error) {
+	err = tx.QueryRow(`SELECT last_indexed_id, last_indexed_height, last_indexed_timestamp, total_supply, circulating_supply, burned_supply, siafund_pool, foundation_subsidy, block_reward_supply, siafund_claims, void_burned_supply, contract_burned_supply, immature_supply FROM global_settings`).Scan(decode(&state.Index.ID), &state.Index.Height, decode(&state.Timestamp), decode(&state.TotalSupply), decode(&state.CirculatingSupply), decode(&state.BurnedSupply), decode(&state.SiafundPool), decode(&state.FoundationSubsidy), decode(&state.BlockRewardSupply), decode(&state.SiafundClaims), decode(&state.VoidBurnedSupply), decode(&state.ContractBurnedSupply), decode(&state.ImmatureSupply))
+	return
+}
+
+func foundationTreasury(tx *txn) (value types.Currency, err error) {
+	const query = `SELECT siacoin_balance FROM address_balances WHERE is_foundation=true`
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return types.ZeroCurrency, fmt.Errorf("failed to query foundation balance: %w", err)
+	}
+	defer rows.Close()
+
+	var balance types.Currency
+	for rows.Next() {
+		if err := rows.Scan(decode(&balance)); err != nil {
+			return types.ZeroCurrency, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		value = value.Add(balance)
+	}
+	return value, rows.Err()
+}
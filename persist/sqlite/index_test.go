@@ -0,0 +1,673 @@
+package sqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"lukechampine.com/frand"
+)
+
+func TestRichList(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var deltas []index.AddressDelta
+	for i := 1; i <= 10; i++ {
+		deltas = append(deltas, index.AddressDelta{
+			Address:  frand.Entropy256(),
+			Incoming: types.Siacoins(uint32(i)),
+		})
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, err := db.RichList(3, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 3 {
+		t.Fatalf("expected 3 balances, got %d", len(balances))
+	}
+	for i, balance := range balances {
+		expected := deltas[len(deltas)-1-i]
+		if balance.Address != expected.Address {
+			t.Fatalf("expected address %v at position %d, got %v", expected.Address, i, balance.Address)
+		} else if !balance.Balance.Equals(expected.Incoming) {
+			t.Fatalf("expected balance %v at position %d, got %v", expected.Incoming, i, balance.Balance)
+		}
+	}
+
+	balances, err = db.RichList(5, 8)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 2 {
+		t.Fatalf("expected 2 balances, got %d", len(balances))
+	} else if balances[1].Address != deltas[0].Address {
+		t.Fatalf("expected address %v, got %v", deltas[0].Address, balances[1].Address)
+	}
+}
+
+func TestRichListAfter(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// several addresses share each balance
+	var deltas []index.AddressDelta
+	for i := 0; i < 10; i++ {
+		deltas = append(deltas, index.AddressDelta{
+			Address:  frand.Entropy256(),
+			Incoming: types.Siacoins(uint32(i/3 + 1)),
+		})
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := db.RichList(100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := db.RichList(3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paged := page
+	for len(page) == 3 {
+		last := page[len(page)-1]
+		page, err = db.RichListAfter(last.Balance, last.Address, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, page...)
+	}
+
+	if len(paged) != len(expected) {
+		t.Fatalf("expected %d balances, got %d", len(expected), len(paged))
+	}
+	for i := range expected {
+		if paged[i] != expected[i] {
+			t.Fatalf("expected %v at position %d, got %v", expected[i], i, paged[i])
+		}
+	}
+
+	// moving an address ahead of the cursor should not duplicate or skip the
+	// remaining addresses
+	cursor := expected[4]
+	if err := db.UpdateState(index.State{}, nil, []index.AddressDelta{{Address: expected[8].Address, Incoming: types.Siacoins(100)}}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	page, err = db.RichListAfter(cursor.Balance, cursor.Address, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page) != 4 {
+		t.Fatalf("expected 4 balances, got %d", len(page))
+	}
+	for _, balance := range page {
+		if balance.Address == expected[8].Address {
+			t.Fatal("expected moved address to be excluded")
+		}
+	}
+}
+
+func BenchmarkFoundationTreasury(b *testing.B) {
+	db, err := OpenDatabase(filepath.Join(b.TempDir(), "supply.sqlite3"), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	const n = 100000
+	deltas := make([]index.AddressDelta, 0, n)
+	for i := 0; i < n; i++ {
+		deltas = append(deltas, index.AddressDelta{
+			Address:  frand.Entropy256(),
+			Incoming: types.Siacoins(uint32(frand.Intn(1e6) + 1)),
+		})
+	}
+	foundation := []index.FoundationAddress{
+		{Address: deltas[0].Address, Role: index.FoundationRolePrimary},
+		{Address: deltas[n/2].Address, Role: index.FoundationRoleFailsafe},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, foundation, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.FoundationTreasury(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSupplyHistory(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log, WithHistoryRetention(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stateAt := func(height uint64) index.State {
+		return index.State{
+			Index:                types.ChainIndex{Height: height, ID: frand.Entropy256()},
+			TotalSupply:          types.Siacoins(uint32(height)),
+			FoundationSubsidy:    types.Siacoins(uint32(height * 2)),
+			BlockRewardSupply:    types.Siacoins(uint32(height * 3)),
+			SiafundClaims:        types.Siacoins(uint32(height * 4)),
+			VoidBurnedSupply:     types.Siacoins(uint32(height * 5)),
+			ContractBurnedSupply: types.Siacoins(uint32(height * 6)),
+			ImmatureSupply:       types.Siacoins(uint32(height * 7)),
+		}
+	}
+
+	var history []index.State
+	for i := uint64(0); i < 20; i++ {
+		history = append(history, stateAt(i))
+	}
+	if err := db.UpdateState(history[19], history, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// history older than the retention window should be pruned
+	if _, err := db.SupplyAtHeight(8); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if state, err := db.SupplyAtHeight(15); err != nil {
+		t.Fatal(err)
+	} else if state != history[15] {
+		t.Fatalf("expected %+v, got %+v", history[15], state)
+	}
+
+	// the current state should match the last applied block
+	if state, err := db.State(); err != nil {
+		t.Fatal(err)
+	} else if state != history[19] {
+		t.Fatalf("expected %+v, got %+v", history[19], state)
+	}
+
+	// revert to height 15 and apply a different block at 16
+	reorg := stateAt(16)
+	if err := db.UpdateState(reorg, []index.State{reorg}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.SupplyAtHeight(17); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if state, err := db.SupplyAtHeight(16); err != nil {
+		t.Fatal(err)
+	} else if state.Index != reorg.Index {
+		t.Fatalf("expected index %v, got %v", reorg.Index, state.Index)
+	}
+
+	// range queries should be sorted and limited
+	if states, err := db.SupplyHistory(0, 100, 100); err != nil {
+		t.Fatal(err)
+	} else if len(states) != 8 {
+		t.Fatalf("expected 8 states, got %d", len(states))
+	} else if states[0].Index != history[9].Index || states[7].Index != reorg.Index {
+		t.Fatalf("unexpected history range %v - %v", states[0].Index, states[7].Index)
+	}
+	if states, err := db.SupplyHistory(12, 15, 3); err != nil {
+		t.Fatal(err)
+	} else if len(states) != 3 || states[0].Index != history[12].Index || states[2].Index != history[14].Index {
+		t.Fatalf("unexpected history %+v", states)
+	}
+}
+
+func TestSampledSupplyHistory(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var history []index.State
+	for i := uint64(0); i < 50; i++ {
+		history = append(history, index.State{
+			Index:        types.ChainIndex{Height: i, ID: frand.Entropy256()},
+			BurnedSupply: types.Siacoins(uint32(i)),
+		})
+	}
+	if err := db.UpdateState(history[49], history, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// every 10th block starting from height 5
+	sampled, err := db.SampledSupplyHistory(5, 40, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sampled) != 4 {
+		t.Fatalf("expected 4 states, got %d", len(sampled))
+	}
+	for i, state := range sampled {
+		if expected := history[5+i*10]; state != expected {
+			t.Fatalf("expected %+v, got %+v", expected, state)
+		}
+	}
+
+	if sampled, err := db.SampledSupplyHistory(0, 49, 1, 3); err != nil {
+		t.Fatal(err)
+	} else if len(sampled) != 3 {
+		t.Fatalf("expected limit of 3 states, got %d", len(sampled))
+	}
+}
+
+func TestSupplyAtTime(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Unix(1700000000, 0).UTC()
+	// block timestamps are not strictly increasing
+	offsets := []time.Duration{0, 10 * time.Minute, 25 * time.Minute, 20 * time.Minute, 25 * time.Minute, 40 * time.Minute}
+	var history []index.State
+	for i, offset := range offsets {
+		history = append(history, index.State{
+			Index:       types.ChainIndex{Height: uint64(i), ID: frand.Entropy256()},
+			Timestamp:   start.Add(offset),
+			TotalSupply: types.Siacoins(uint32(i)),
+		})
+	}
+	if err := db.UpdateState(history[len(history)-1], history, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t      time.Time
+		height uint64
+	}{
+		{start, 0},
+		{start.Add(15 * time.Minute), 1},
+		{start.Add(20 * time.Minute), 3},
+		// ties are broken by the greatest height
+		{start.Add(30 * time.Minute), 4},
+		{start.Add(time.Hour), 5},
+	}
+	for _, test := range tests {
+		if state, err := db.SupplyAtTime(test.t); err != nil {
+			t.Fatal(err)
+		} else if state != history[test.height] {
+			t.Fatalf("expected state at height %d for %v, got %+v", test.height, test.t, state)
+		}
+	}
+
+	if _, err := db.SupplyAtTime(start.Add(-time.Second)); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPruneEmptyAddresses(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	countAddresses := func() (n int) {
+		t.Helper()
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM address_balances`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	addr := types.Address(frand.Entropy256())
+	foundationAddr := types.Address(frand.Entropy256())
+	value := types.Siacoins(100)
+
+	// fund both addresses
+	deltas := []index.AddressDelta{
+		{Address: addr, Incoming: value},
+		{Address: foundationAddr, Incoming: value},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, []index.FoundationAddress{{Address: foundationAddr, Role: index.FoundationRolePrimary}}, nil); err != nil {
+		t.Fatal(err)
+	} else if n := countAddresses(); n != 2 {
+		t.Fatalf("expected 2 addresses, got %d", n)
+	}
+
+	// spend everything
+	deltas = []index.AddressDelta{
+		{Address: addr, Outgoing: value},
+		{Address: foundationAddr, Outgoing: value},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if n := countAddresses(); n != 1 {
+		t.Fatalf("expected 1 address, got %d", n)
+	}
+
+	// the foundation address should remain with a zero balance
+	if treasury, err := db.FoundationTreasury(); err != nil {
+		t.Fatal(err)
+	} else if !treasury.IsZero() {
+		t.Fatalf("expected empty treasury, got %v", treasury)
+	}
+	balances, err := db.RichList(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 1 || balances[0].Address != foundationAddr {
+		t.Fatalf("expected only the foundation address, got %v", balances)
+	}
+
+	// an address that receives and spends in the same update should not be
+	// stored
+	deltas = []index.AddressDelta{
+		{Address: frand.Entropy256(), Incoming: value, Outgoing: value},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if n := countAddresses(); n != 1 {
+		t.Fatalf("expected 1 address, got %d", n)
+	}
+}
+
+func TestRemoveFoundationAddresses(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	funded, empty := types.Address(frand.Entropy256()), types.Address(frand.Entropy256())
+	value := types.Siacoins(100)
+	deltas := []index.AddressDelta{{Address: funded, Incoming: value}}
+	foundation := []index.FoundationAddress{
+		{Address: funded, Role: index.FoundationRolePrimary},
+		{Address: empty, Role: index.FoundationRoleFailsafe},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, foundation, nil); err != nil {
+		t.Fatal(err)
+	} else if treasury, err := db.FoundationTreasury(); err != nil {
+		t.Fatal(err)
+	} else if !treasury.Equals(value) {
+		t.Fatalf("expected treasury %v, got %v", value, treasury)
+	} else if balance, foundation, err := db.AddressBalance(empty); err != nil {
+		t.Fatal(err)
+	} else if !balance.IsZero() || !foundation {
+		t.Fatalf("expected empty foundation address, got %v (foundation: %v)", balance, foundation)
+	}
+
+	if err := db.UpdateState(index.State{}, nil, nil, nil, nil, []types.Address{funded, empty}); err != nil {
+		t.Fatal(err)
+	} else if treasury, err := db.FoundationTreasury(); err != nil {
+		t.Fatal(err)
+	} else if !treasury.IsZero() {
+		t.Fatalf("expected empty treasury, got %v", treasury)
+	}
+
+	if balance, foundation, err := db.AddressBalance(funded); err != nil {
+		t.Fatal(err)
+	} else if !balance.Equals(value) || foundation {
+		t.Fatalf("expected non-foundation balance %v, got %v (foundation: %v)", value, balance, foundation)
+	} else if _, _, err := db.AddressBalance(empty); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// the funded address should still be tracked, the empty address should
+	// be removed
+	balances, err := db.RichList(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 1 || balances[0].Address != funded {
+		t.Fatalf("expected only the funded address, got %v", balances)
+	}
+}
+
+func TestFoundationTreasuryFailsafe(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	primary, failsafe := types.Address(frand.Entropy256()), types.Address(frand.Entropy256())
+	deltas := []index.AddressDelta{
+		{Address: primary, Incoming: types.Siacoins(100)},
+		{Address: failsafe, Incoming: types.Siacoins(50)},
+		{Address: frand.Entropy256(), Incoming: types.Siacoins(25)},
+	}
+	foundation := []index.FoundationAddress{
+		{Address: primary, Role: index.FoundationRolePrimary},
+		{Address: failsafe, Role: index.FoundationRoleFailsafe},
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, foundation, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if treasury, err := db.FoundationTreasury(); err != nil {
+		t.Fatal(err)
+	} else if expected := types.Siacoins(150); !treasury.Equals(expected) {
+		t.Fatalf("expected treasury %v, got %v", expected, treasury)
+	}
+
+	// the foundation addresses are listed by balance
+	if balances, err := db.FoundationAddresses(); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 2 {
+		t.Fatalf("expected 2 foundation addresses, got %v", balances)
+	} else if balances[0].Address != primary || !balances[0].Balance.Equals(types.Siacoins(100)) {
+		t.Fatalf("expected primary address first, got %v", balances[0])
+	} else if balances[1].Address != failsafe || !balances[1].Balance.Equals(types.Siacoins(50)) {
+		t.Fatalf("expected failsafe address second, got %v", balances[1])
+	}
+
+	for _, fa := range foundation {
+		var role string
+		if err := db.db.QueryRow(`SELECT foundation_role FROM address_balances WHERE address=$1`, encode(fa.Address)).Scan(&role); err != nil {
+			t.Fatal(err)
+		} else if role != string(fa.Role) {
+			t.Fatalf("expected role %q, got %q", fa.Role, role)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	state := index.State{
+		Index:             types.ChainIndex{Height: 1, ID: frand.Entropy256()},
+		CirculatingSupply: types.Siacoins(150),
+		TotalSupply:       types.Siacoins(150),
+	}
+	deltas := []index.AddressDelta{
+		{Address: frand.Entropy256(), Incoming: types.Siacoins(100)},
+		{Address: frand.Entropy256(), Incoming: types.Siacoins(50)},
+	}
+	if err := db.UpdateState(state, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	audit, err := db.Audit()
+	if err != nil {
+		t.Fatal(err)
+	} else if audit.Index != state.Index {
+		t.Fatalf("expected index %v, got %v", state.Index, audit.Index)
+	} else if !audit.AddressBalances.Equals(types.Siacoins(150)) {
+		t.Fatalf("expected balances %v, got %v", types.Siacoins(150), audit.AddressBalances)
+	} else if !audit.Consistent() {
+		t.Fatalf("expected consistent audit, got %+v", audit)
+	}
+
+	// drift the circulating supply from the balances
+	state.CirculatingSupply = types.Siacoins(140)
+	if err := db.UpdateState(state, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if audit, err := db.Audit(); err != nil {
+		t.Fatal(err)
+	} else if audit.Consistent() {
+		t.Fatalf("expected inconsistent audit, got %+v", audit)
+	}
+}
+
+func TestSupplyExcluding(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	foundation, escrow, other := types.Address(frand.Entropy256()), types.Address(frand.Entropy256()), types.Address(frand.Entropy256())
+	state := index.State{
+		Index:             types.ChainIndex{Height: 1, ID: frand.Entropy256()},
+		CirculatingSupply: types.Siacoins(100),
+	}
+	deltas := []index.AddressDelta{
+		{Address: foundation, Incoming: types.Siacoins(10)},
+		{Address: escrow, Incoming: types.Siacoins(20)},
+		{Address: other, Incoming: types.Siacoins(70)},
+	}
+	if err := db.UpdateState(state, nil, deltas, nil, []index.FoundationAddress{{Address: foundation, Role: index.FoundationRolePrimary}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the foundation address is only counted once and unindexed addresses
+	// are ignored
+	if _, excluded, err := db.SupplyExcluding([]types.Address{escrow, foundation, frand.Entropy256()}); err != nil {
+		t.Fatal(err)
+	} else if !excluded.Equals(types.Siacoins(30)) {
+		t.Fatalf("expected %v excluded, got %v", types.Siacoins(30), excluded)
+	}
+
+	if _, excluded, err := db.SupplyExcluding(nil); err != nil {
+		t.Fatal(err)
+	} else if !excluded.Equals(types.Siacoins(10)) {
+		t.Fatalf("expected %v excluded, got %v", types.Siacoins(10), excluded)
+	}
+}
+
+func TestLockedSupply(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkLocked := func(expected types.Currency) {
+		t.Helper()
+		if _, locked, err := db.LockedSupply(); err != nil {
+			t.Fatal(err)
+		} else if !locked.Equals(expected) {
+			t.Fatalf("expected locked supply %v, got %v", expected, locked)
+		}
+	}
+
+	state := index.State{Index: types.ChainIndex{Height: 1, ID: frand.Entropy256()}}
+	maturing := []index.MaturityDelta{
+		{MaturityHeight: 3, Incoming: types.Siacoins(100)},
+		{MaturityHeight: 5, Incoming: types.Siacoins(50)},
+	}
+	if err := db.UpdateState(state, nil, nil, maturing, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(types.Siacoins(150))
+
+	// outputs are unlocked at their maturity height
+	state.Index = types.ChainIndex{Height: 3, ID: frand.Entropy256()}
+	if err := db.UpdateState(state, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(types.Siacoins(50))
+
+	// reverting the block that created them moves the value back out
+	state.Index = types.ChainIndex{Height: 2, ID: frand.Entropy256()}
+	reverted := []index.MaturityDelta{{MaturityHeight: 5, Outgoing: types.Siacoins(50)}}
+	if err := db.UpdateState(state, nil, nil, reverted, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(types.Siacoins(100))
+}
+
+func BenchmarkUpdateState(b *testing.B) {
+	db, err := OpenDatabase(filepath.Join(b.TempDir(), "supply.sqlite3"), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	// each block spends from half of the existing addresses and creates
+	// the same number of new addresses
+	const n = 10000
+	addresses := make([]types.Address, n)
+	deltas := make([]index.AddressDelta, n)
+	for i := range deltas {
+		addresses[i] = frand.Entropy256()
+		deltas[i] = index.AddressDelta{Address: addresses[i], Incoming: types.Siacoins(1000)}
+	}
+	if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range deltas {
+			if j%2 == 0 {
+				deltas[j] = index.AddressDelta{Address: addresses[j], Outgoing: types.Siacoins(1)}
+			} else {
+				deltas[j] = index.AddressDelta{Address: frand.Entropy256(), Incoming: types.Siacoins(1)}
+			}
+		}
+		if err := db.UpdateState(index.State{}, nil, deltas, nil, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSync(b *testing.B) {
+	// each block pays a few addresses and records its history, similar to
+	// syncing one block at a time
+	const blocks = 2000
+	deltas := make([]index.AddressDelta, 10)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := OpenDatabase(filepath.Join(b.TempDir(), "supply.sqlite3"), zap.NewNop())
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		for height := uint64(0); height < blocks; height++ {
+			for j := range deltas {
+				deltas[j] = index.AddressDelta{Address: frand.Entropy256(), Incoming: types.Siacoins(1)}
+			}
+			state := index.State{Index: types.ChainIndex{Height: height, ID: frand.Entropy256()}}
+			if err := db.UpdateState(state, []index.State{state}, deltas, nil, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.StopTimer()
+		db.Close()
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(b.N*blocks)/b.Elapsed().Seconds(), "blocks/s")
+}
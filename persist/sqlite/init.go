@@ -45,7 +45,13 @@ func (s *Store) upgradeDatabase(current, target int64) error {
 				return fmt.Errorf("failed to enable foreign key deferral: %w", err)
 			} else if err := fn(tx, log); err != nil {
 				return err
-			} else if err := foreignKeyCheck(tx, log); err != nil {
+			} else if version == resyncVersion {
+				log.Info("resetting index to fill the new supply columns, the chain will be resynced")
+				if err := resetIndex(tx); err != nil {
+					return fmt.Errorf("failed to reset index: %w", err)
+				}
+			}
+			if err := foreignKeyCheck(tx, log); err != nil {
 				return fmt.Errorf("failed foreign key check: %w", err)
 			}
 			return setDBVersion(tx, version)
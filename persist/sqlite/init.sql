@@ -2,10 +2,35 @@ CREATE TABLE address_balances (
     id INTEGER PRIMARY KEY,
     address BLOB UNIQUE NOT NULL,
     siacoin_balance BLOB NOT NULL,
-    is_foundation BOOL NOT NULL DEFAULT false
+    is_foundation BOOL NOT NULL DEFAULT false,
+    foundation_role TEXT CHECK (foundation_role IN ('primary', 'failsafe')) -- NULL for non-foundation addresses
 );
 
-CREATE INDEX address_balances_is_foundation ON address_balances (is_foundation);
+CREATE INDEX address_balances_is_foundation_siacoin_balance ON address_balances (siacoin_balance) WHERE is_foundation=true;
+CREATE INDEX address_balances_siacoin_balance_address ON address_balances (siacoin_balance DESC, address DESC);
+
+CREATE TABLE supply_history (
+    height INTEGER PRIMARY KEY,
+    block_id BLOB UNIQUE NOT NULL,
+    block_timestamp INTEGER NOT NULL,
+    total_supply BLOB NOT NULL,
+    circulating_supply BLOB NOT NULL,
+    burned_supply BLOB NOT NULL,
+    siafund_pool BLOB NOT NULL,
+    foundation_subsidy BLOB NOT NULL DEFAULT X'00000000000000000000000000000000',
+    block_reward_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000',
+    siafund_claims BLOB NOT NULL DEFAULT X'00000000000000000000000000000000',
+    void_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000',
+    contract_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000',
+    immature_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000'
+);
+
+CREATE INDEX supply_history_block_timestamp ON supply_history (block_timestamp);
+
+CREATE TABLE immature_supply (
+    maturity_height INTEGER PRIMARY KEY, -- the height the outputs can be spent at
+    siacoin_value BLOB NOT NULL -- the combined value of the outputs
+);
 
 CREATE TABLE global_settings (
     id INTEGER PRIMARY KEY NOT NULL DEFAULT 0 CHECK (id = 0), -- enforce a single row
@@ -13,6 +38,14 @@ CREATE TABLE global_settings (
     total_supply BLOB NOT NULL, -- the total supply of Siacoin
     circulating_supply BLOB NOT NULL, -- the circulating supply of Siacoin
     burned_supply BLOB NOT NULL, -- the supply that has been verifiably burned
+    siafund_pool BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the value of the siafund pool
+    foundation_subsidy BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the cumulative foundation subsidy
+    block_reward_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the cumulative block rewards
+    siafund_claims BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the cumulative siafund claims
+    void_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the cumulative value sent to the void address
+    contract_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the cumulative value burned by expired contracts
+    immature_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000', -- the block rewards and subsidies that have not matured
     last_indexed_height INTEGER NOT NULL, -- the height of the last chain index that was processed
-    last_indexed_id BLOB NOT NULL -- the block ID of the last chain index that was processed
+    last_indexed_id BLOB NOT NULL, -- the block ID of the last chain index that was processed
+    last_indexed_timestamp INTEGER NOT NULL DEFAULT 0 -- the timestamp of the last block that was processed
 );
@@ -1,10 +1,215 @@
 package sqlite
 
 import (
+	"fmt"
+
+	"go.sia.tech/core/types"
+
 	"go.uber.org/zap"
 )
 
+func migrateVersion2(tx *txn, _ *zap.Logger) error {
+	_, err := tx.Exec(`ALTER TABLE global_settings ADD COLUMN last_indexed_timestamp INTEGER NOT NULL DEFAULT 0;`)
+	return err
+}
+
+func migrateVersion3(tx *txn, _ *zap.Logger) error {
+	// the siafund pool is not cumulative, it will be set when the next block
+	// is indexed.
+	_, err := tx.Exec(`ALTER TABLE global_settings ADD COLUMN siafund_pool BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`)
+	return err
+}
+
+func migrateVersion4(tx *txn, _ *zap.Logger) error {
+	_, err := tx.Exec(`CREATE INDEX address_balances_siacoin_balance ON address_balances (siacoin_balance DESC);`)
+	return err
+}
+
+func migrateVersion5(tx *txn, _ *zap.Logger) error {
+	// replace the foundation index with a partial covering index for the
+	// treasury query
+	if _, err := tx.Exec(`DROP INDEX address_balances_is_foundation;`); err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	_, err := tx.Exec(`CREATE INDEX address_balances_is_foundation_siacoin_balance ON address_balances (siacoin_balance) WHERE is_foundation=true;`)
+	return err
+}
+
+func migrateVersion6(tx *txn, _ *zap.Logger) error {
+	// history is only recorded for blocks indexed after the migration
+	_, err := tx.Exec(`CREATE TABLE supply_history (
+    height INTEGER PRIMARY KEY,
+    block_id BLOB UNIQUE NOT NULL,
+    block_timestamp INTEGER NOT NULL,
+    total_supply BLOB NOT NULL,
+    circulating_supply BLOB NOT NULL,
+    burned_supply BLOB NOT NULL,
+    siafund_pool BLOB NOT NULL
+);`)
+	return err
+}
+
+func migrateVersion7(tx *txn, _ *zap.Logger) error {
+	// empty addresses are no longer stored
+	_, err := tx.Exec(`DELETE FROM address_balances WHERE siacoin_balance=X'00000000000000000000000000000000' AND is_foundation=false;`)
+	return err
+}
+
+func migrateVersion8(tx *txn, _ *zap.Logger) error {
+	// failsafe addresses were not previously tracked and the initial
+	// foundation address was recorded without its role. The roles are
+	// filled when the chain is resynced.
+	_, err := tx.Exec(`ALTER TABLE address_balances ADD COLUMN foundation_role TEXT CHECK (foundation_role IN ('primary', 'failsafe'));`)
+	return err
+}
+
+func migrateVersion9(tx *txn, _ *zap.Logger) error {
+	// replace the balance index with one that includes the address so the
+	// rich list can be paged by (balance, address)
+	if _, err := tx.Exec(`DROP INDEX address_balances_siacoin_balance;`); err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	_, err := tx.Exec(`CREATE INDEX address_balances_siacoin_balance_address ON address_balances (siacoin_balance DESC, address DESC);`)
+	return err
+}
+
+func migrateVersion10(tx *txn, _ *zap.Logger) error {
+	// the cumulative subsidy is filled when the chain is resynced
+	if _, err := tx.Exec(`ALTER TABLE global_settings ADD COLUMN foundation_subsidy BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add foundation subsidy column: %w", err)
+	} else if _, err := tx.Exec(`ALTER TABLE supply_history ADD COLUMN foundation_subsidy BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add foundation subsidy history column: %w", err)
+	}
+	return nil
+}
+
+func migrateVersion11(tx *txn, _ *zap.Logger) error {
+	if _, err := tx.Exec(`ALTER TABLE global_settings ADD COLUMN block_reward_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add block reward supply column: %w", err)
+	} else if _, err := tx.Exec(`ALTER TABLE supply_history ADD COLUMN block_reward_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add block reward supply history column: %w", err)
+	}
+	return nil
+}
+
+func migrateVersion12(tx *txn, _ *zap.Logger) error {
+	if _, err := tx.Exec(`ALTER TABLE global_settings ADD COLUMN siafund_claims BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add siafund claims column: %w", err)
+	} else if _, err := tx.Exec(`ALTER TABLE supply_history ADD COLUMN siafund_claims BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+		return fmt.Errorf("failed to add siafund claims history column: %w", err)
+	}
+	return nil
+}
+
+func migrateVersion13(tx *txn, _ *zap.Logger) error {
+	for _, table := range []string{"global_settings", "supply_history"} {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN void_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+			return fmt.Errorf("failed to add void burned supply column to %s: %w", table, err)
+		} else if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN contract_burned_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+			return fmt.Errorf("failed to add contract burned supply column to %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func migrateVersion14(tx *txn, _ *zap.Logger) error {
+	// immature outputs are tracked by maturity height, and the immature
+	// block rewards and subsidies are tracked with the supply
+	if _, err := tx.Exec(`CREATE TABLE immature_supply (
+    maturity_height INTEGER PRIMARY KEY,
+    siacoin_value BLOB NOT NULL
+);`); err != nil {
+		return fmt.Errorf("failed to create immature supply table: %w", err)
+	}
+	for _, table := range []string{"global_settings", "supply_history"} {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN immature_supply BLOB NOT NULL DEFAULT X'00000000000000000000000000000000';`); err != nil {
+			return fmt.Errorf("failed to add immature supply column to %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func migrateVersion15(tx *txn, _ *zap.Logger) error {
+	// the history can be queried by block timestamp
+	_, err := tx.Exec(`CREATE INDEX supply_history_block_timestamp ON supply_history (block_timestamp);`)
+	return err
+}
+
+// resetIndex clears the indexed state so the chain is rescanned from genesis.
+func resetIndex(tx *txn) error {
+	if _, err := tx.Exec(`DELETE FROM address_balances;`); err != nil {
+		return fmt.Errorf("failed to clear address balances: %w", err)
+	} else if _, err := tx.Exec(`DELETE FROM supply_history;`); err != nil {
+		return fmt.Errorf("failed to clear supply history: %w", err)
+	}
+
+	var hasImmature bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type='table' AND name='immature_supply')`).Scan(&hasImmature); err != nil {
+		return fmt.Errorf("failed to check for immature supply table: %w", err)
+	} else if hasImmature {
+		if _, err := tx.Exec(`DELETE FROM immature_supply;`); err != nil {
+			return fmt.Errorf("failed to clear immature supply: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE global_settings SET (last_indexed_height, last_indexed_id, last_indexed_timestamp) = (0, $1, 0)`, encode(types.BlockID{}))
+	if err != nil {
+		return fmt.Errorf("failed to reset state: %w", err)
+	}
+
+	// cumulative supply columns are added by later migrations, so reset
+	// every supply column that exists when the migration runs. Otherwise
+	// they would be counted twice when the chain is resynced.
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info('global_settings') WHERE type='BLOB' AND name <> 'last_indexed_id'`)
+	if err != nil {
+		return fmt.Errorf("failed to query supply columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan column name: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query supply columns: %w", err)
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		if _, err := tx.Exec(`UPDATE global_settings SET `+column+`=$1`, encode(types.ZeroCurrency)); err != nil {
+			return fmt.Errorf("failed to reset %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// resyncVersion is the latest version that added values that can only be
+// calculated by rescanning the chain. Databases older than it are reset once,
+// in the same transaction as the migration to resyncVersion, instead of once
+// per migration. An interrupted upgrade is resumed below resyncVersion, so the
+// reset can't be skipped.
+const resyncVersion = 14
+
 // migrations is a list of functions that are run to migrate the database from
 // one version to the next. Migrations are used to update existing databases to
 // match the schema in init.sql.
-var migrations = []func(tx *txn, log *zap.Logger) error{}
+var migrations = []func(tx *txn, log *zap.Logger) error{
+	migrateVersion2,
+	migrateVersion3,
+	migrateVersion4,
+	migrateVersion5,
+	migrateVersion6,
+	migrateVersion7,
+	migrateVersion8,
+	migrateVersion9,
+	migrateVersion10,
+	migrateVersion11,
+	migrateVersion12,
+	migrateVersion13,
+	migrateVersion14,
+	migrateVersion15,
+}
@@ -2,132 +2,40 @@ package sqlite
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"go.sia.tech/cmc-supply-api/index"
 	"go.sia.tech/core/types"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
 )
 
-// nolint:misspell
-const initialSchema = `CREATE TABLE chain_indices (
+const initialSchema = `CREATE TABLE address_balances (
 	id INTEGER PRIMARY KEY,
-	block_id BLOB UNIQUE NOT NULL,
-	height INTEGER UNIQUE NOT NULL
-);
-CREATE INDEX chain_indices_height ON chain_indices (block_id, height);
-
-CREATE TABLE sia_addresses (
-	id INTEGER PRIMARY KEY,
-	sia_address BLOB UNIQUE NOT NULL,
+	address BLOB UNIQUE NOT NULL,
 	siacoin_balance BLOB NOT NULL,
-	immature_siacoin_balance BLOB NOT NULL,
-	siafund_balance INTEGER NOT NULL
-);
-
-CREATE TABLE siacoin_elements (
-	id BLOB PRIMARY KEY,
-	siacoin_value BLOB NOT NULL,
-	merkle_proof BLOB NOT NULL,
-	leaf_index INTEGER NOT NULL,
-	maturity_height INTEGER NOT NULL, /* stored as int64 for easier querying */
-	address_id INTEGER NOT NULL REFERENCES sia_addresses (id),
-	matured BOOLEAN NOT NULL, /* tracks whether the value has been added to the address balance */
-	chain_index_id INTEGER NOT NULL REFERENCES chain_indices (id),
-	spent_index_id INTEGER REFERENCES chain_indices (id) /* soft delete */
-);
-CREATE INDEX siacoin_elements_address_id ON siacoin_elements (address_id);
-CREATE INDEX siacoin_elements_maturity_height_matured ON siacoin_elements (maturity_height, matured);
-CREATE INDEX siacoin_elements_chain_index_id ON siacoin_elements (chain_index_id);
-CREATE INDEX siacoin_elements_spent_index_id ON siacoin_elements (spent_index_id);
-CREATE INDEX siacoin_elements_address_id_spent_index_id ON siacoin_elements(address_id, spent_index_id);
-
-CREATE TABLE siafund_elements (
-	id BLOB PRIMARY KEY,
-	claim_start BLOB NOT NULL,
-	merkle_proof BLOB NOT NULL,
-	leaf_index INTEGER NOT NULL,
-	siafund_value INTEGER NOT NULL,
-	address_id INTEGER NOT NULL REFERENCES sia_addresses (id),
-	chain_index_id INTEGER NOT NULL REFERENCES chain_indices (id),
-	spent_index_id INTEGER REFERENCES chain_indices (id) /* soft delete */
-);
-CREATE INDEX siafund_elements_address_id ON siafund_elements (address_id);
-CREATE INDEX siafund_elements_chain_index_id ON siafund_elements (chain_index_id);
-CREATE INDEX siafund_elements_spent_index_id ON siafund_elements (spent_index_id);
-CREATE INDEX siafund_elements_address_id_spent_index_id ON siafund_elements(address_id, spent_index_id);
-
-CREATE TABLE state_tree (
-	row INTEGER,
-	column INTEGER,
-	value BLOB NOT NULL,
-	PRIMARY KEY (row, column)
-);
-
-CREATE TABLE events (
-	id INTEGER PRIMARY KEY,
-	chain_index_id INTEGER NOT NULL REFERENCES chain_indices (id),
-	event_id BLOB UNIQUE NOT NULL,
-	maturity_height INTEGER NOT NULL,
-	date_created INTEGER NOT NULL,
-	event_type TEXT NOT NULL,
-	event_data BLOB NOT NULL
-);
-CREATE INDEX events_chain_index_id ON events (chain_index_id);
-
-CREATE TABLE event_addresses (
-	event_id INTEGER NOT NULL REFERENCES events (id) ON DELETE CASCADE,
-	address_id INTEGER NOT NULL REFERENCES sia_addresses (id),
-	PRIMARY KEY (event_id, address_id)
-);
-CREATE INDEX event_addresses_event_id_idx ON event_addresses (event_id);
-CREATE INDEX event_addresses_address_id_idx ON event_addresses (address_id);
-
-CREATE TABLE wallets (
-	id INTEGER PRIMARY KEY,
-	friendly_name TEXT NOT NULL,
-	description TEXT NOT NULL,
-	date_created INTEGER NOT NULL,
-	last_updated INTEGER NOT NULL,
-	extra_data BLOB
+	is_foundation BOOL NOT NULL DEFAULT false
 );
 
-CREATE TABLE wallet_addresses (
-	wallet_id INTEGER NOT NULL REFERENCES wallets (id),
-	address_id INTEGER NOT NULL REFERENCES sia_addresses (id),
-	description TEXT NOT NULL,
-	spend_policy BLOB,
-	extra_data BLOB,
-	UNIQUE (wallet_id, address_id)
-);
-CREATE INDEX wallet_addresses_wallet_id ON wallet_addresses (wallet_id);
-CREATE INDEX wallet_addresses_address_id ON wallet_addresses (address_id);
-
-CREATE TABLE syncer_peers (
-	peer_address TEXT PRIMARY KEY NOT NULL,
-	first_seen INTEGER NOT NULL
-);
-
-CREATE TABLE syncer_bans (
-	net_cidr TEXT PRIMARY KEY NOT NULL,
-	expiration INTEGER NOT NULL,
-	reason TEXT NOT NULL
-);
-CREATE INDEX syncer_bans_expiration_index ON syncer_bans (expiration);
+CREATE INDEX address_balances_is_foundation ON address_balances (is_foundation);
 
 CREATE TABLE global_settings (
 	id INTEGER PRIMARY KEY NOT NULL DEFAULT 0 CHECK (id = 0), -- enforce a single row
 	db_version INTEGER NOT NULL, -- used for migrations
-	index_mode INTEGER, -- the mode of the data store
-	last_indexed_tip BLOB NOT NULL, -- the last chain index that was processed
-	element_num_leaves INTEGER NOT NULL -- the number of leaves in the state tree
+	total_supply BLOB NOT NULL, -- the total supply of Siacoin
+	circulating_supply BLOB NOT NULL, -- the circulating supply of Siacoin
+	burned_supply BLOB NOT NULL, -- the supply that has been verifiably burned
+	last_indexed_height INTEGER NOT NULL, -- the height of the last chain index that was processed
+	last_indexed_id BLOB NOT NULL -- the block ID of the last chain index that was processed
 );`
 
 func TestMigrationConsistency(t *testing.T) {
-	fp := filepath.Join(t.TempDir(), "walletd.sqlite3")
-	db, err := sql.Open("sqlite3", sqliteFilepath(fp))
+	fp := filepath.Join(t.TempDir(), "supply.sqlite3")
+	db, err := sql.Open("sqlite3", sqliteFilepath(fp, defaultBusyTimeout))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,7 +46,7 @@ func TestMigrationConsistency(t *testing.T) {
 	}
 
 	// initialize the settings table
-	_, err = db.Exec(`INSERT INTO global_settings (id, db_version, index_mode, element_num_leaves, last_indexed_tip) VALUES (0, 1, 0, 0, ?)`, encode(types.ChainIndex{}))
+	_, err = db.Exec(`INSERT INTO global_settings (id, db_version, total_supply, circulating_supply, burned_supply, last_indexed_height, last_indexed_id) VALUES (0, 1, ?, ?, ?, 0, ?)`, encode(types.ZeroCurrency), encode(types.ZeroCurrency), encode(types.ZeroCurrency), encode(types.BlockID{}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,7 +80,7 @@ func TestMigrationConsistency(t *testing.T) {
 		t.Fatalf("expected version %d, got %d", expectedVersion, v)
 	}
 
-	fp2 := filepath.Join(t.TempDir(), "walletd.sqlite3")
+	fp2 := filepath.Join(t.TempDir(), "supply.sqlite3")
 	baseline, err := OpenDatabase(fp2, zap.NewNop())
 	if err != nil {
 		t.Fatal(err)
@@ -321,3 +229,135 @@ func TestMigrationConsistency(t *testing.T) {
 		}
 	}
 }
+
+func TestMigrationResync(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "supply.sqlite3")
+	db, err := sql.Open("sqlite3", sqliteFilepath(fp, defaultBusyTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// an index created before the supply columns were added
+	if _, err := db.Exec(initialSchema); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO global_settings (id, db_version, total_supply, circulating_supply, burned_supply, last_indexed_height, last_indexed_id) VALUES (0, 1, ?, ?, ?, 100, ?)`, encode(types.Siacoins(100)), encode(types.Siacoins(90)), encode(types.Siacoins(1)), encode(types.BlockID{1}))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec(`INSERT INTO address_balances (address, siacoin_balance) VALUES (?, ?)`, encode(types.Address{1}), encode(types.Siacoins(90))); err != nil {
+		t.Fatal(err)
+	} else if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenDatabase(fp, zaptest.NewLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// the new columns can't be filled from the old index, so it is reset
+	// and the chain is resynced
+	if state, err := store.State(); err != nil {
+		t.Fatal(err)
+	} else if state.Index != (types.ChainIndex{}) || !state.TotalSupply.IsZero() || !state.CirculatingSupply.IsZero() || !state.BurnedSupply.IsZero() {
+		t.Fatalf("expected empty state, got %+v", state)
+	} else if balances, err := store.RichList(10, 0); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 0 {
+		t.Fatalf("expected no balances, got %v", balances)
+	}
+}
+
+func TestMigrationRollback(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "supply.sqlite3")
+	log := zaptest.NewLogger(t)
+	store, err := OpenDatabase(fp, log)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectedVersion := int64(len(migrations) + 1)
+
+	// add a migration that partially succeeds before failing
+	original := migrations
+	t.Cleanup(func() { migrations = original })
+	migrations = append(append([]func(*txn, *zap.Logger) error(nil), original...), func(tx *txn, _ *zap.Logger) error {
+		if _, err := tx.Exec(`CREATE TABLE rollback_test (id INTEGER PRIMARY KEY);`); err != nil {
+			return err
+		}
+		return errors.New("migration failed")
+	})
+
+	if _, err := OpenDatabase(fp, log); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+
+	db, err := sql.Open("sqlite3", sqliteFilepath(fp, defaultBusyTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if v := getDBVersion(db); v != expectedVersion {
+		t.Fatalf("expected version %d, got %d", expectedVersion, v)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_schema WHERE type='table' AND name='rollback_test'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("expected failed migration to be rolled back")
+	}
+}
+
+func TestResetIndex(t *testing.T) {
+	store, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), zaptest.NewLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	state := index.State{
+		Index:                types.ChainIndex{Height: 10, ID: types.BlockID{1}},
+		TotalSupply:          types.Siacoins(100),
+		CirculatingSupply:    types.Siacoins(100),
+		BurnedSupply:         types.Siacoins(1),
+		SiafundPool:          types.Siacoins(2),
+		FoundationSubsidy:    types.Siacoins(3),
+		BlockRewardSupply:    types.Siacoins(4),
+		SiafundClaims:        types.Siacoins(5),
+		VoidBurnedSupply:     types.Siacoins(6),
+		ContractBurnedSupply: types.Siacoins(7),
+		ImmatureSupply:       types.Siacoins(9),
+	}
+	maturing := []index.MaturityDelta{{MaturityHeight: 20, Incoming: types.Siacoins(8)}}
+	deltas := []index.AddressDelta{{Address: types.Address{1}, Incoming: types.Siacoins(100)}}
+	if err := store.UpdateState(state, []index.State{state}, deltas, maturing, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if err := store.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every cumulative value must be reset, or it would be counted twice
+	// when the chain is resynced
+	state, locked, err := store.LockedSupply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Timestamp = time.Time{} // the zero timestamp is stored as the Unix epoch
+	if state != (index.State{}) {
+		t.Fatalf("expected empty state, got %+v", state)
+	} else if !locked.IsZero() {
+		t.Fatalf("expected no locked supply, got %v", locked)
+	}
+
+	if _, err := store.SupplyAtHeight(10); !errors.Is(err, index.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if balances, err := store.RichList(10, 0); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 0 {
+		t.Fatalf("expected no balances, got %v", balances)
+	}
+}
@@ -145,6 +145,16 @@ func (tx *txn) Prepare(query string) (*stmt, error) {
 	}, nil
 }
 
+// Stmt returns a transaction-specific statement from a statement prepared on
+// the database. The returned statement is closed when the transaction ends.
+func (tx *txn) Stmt(s *stmt) *stmt {
+	return &stmt{
+		Stmt:  tx.Tx.Stmt(s.Stmt),
+		query: s.query,
+		log:   tx.log.Named("statement"),
+	}
+}
+
 // Query executes a query that returns rows, typically a SELECT. The
 // args are for any placeholder parameters in the query.
 func (tx *txn) Query(query string, args ...any) (*rows, error) {
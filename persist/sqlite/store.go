@@ -1,11 +1,13 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"strings"
 	"time"
 
@@ -15,21 +17,102 @@ import (
 	"lukechampine.com/frand"
 )
 
+// MinHistoryRetention is the minimum number of blocks of supply history that
+// can be retained when pruning is enabled. Reorgs revert and replace history
+// near the tip, so the retained window must be deeper than any reorg the index
+// is expected to handle.
+const MinHistoryRetention = 144 // 1 day
+
+// MemoryDatabase is the path of an in-memory database. The database is
+// discarded when the store is closed.
+const MemoryDatabase = ":memory:"
+
 type (
+	// An Option configures a Store.
+	Option func(*Store)
+
 	// A Store is a persistent store that uses a SQL database as its backend.
 	Store struct {
 		indexMode wallet.IndexMode
 
+		// historyRetention is the number of blocks of supply history to
+		// keep. If zero, all history is kept.
+		historyRetention uint64
+		// busyTimeout is how long a connection waits for a lock held by
+		// another connection before returning a busy error.
+		busyTimeout time.Duration
+
 		db  *sql.DB
 		log *zap.Logger
+
+		// stmts are prepared once when the database is opened and reused
+		// by each transaction.
+		stmts statements
+	}
+
+	// statements are the prepared statements used to update the index.
+	statements struct {
+		removeFoundationAddress *stmt
+		deleteEmptyAddress      *stmt
+		insertFoundationAddress *stmt
+		updateBalance           *stmt
+		updateImmature          *stmt
+		insertHistory           *stmt
 	}
 )
 
-// Close closes the underlying database.
+// WithHistoryRetention sets the number of blocks of supply history to keep.
+// Older history is pruned as new blocks are indexed. If n is zero, all history
+// is kept. Callers should not set n below MinHistoryRetention.
+func WithHistoryRetention(n uint64) Option {
+	return func(s *Store) {
+		s.historyRetention = n
+	}
+}
+
+// WithBusyTimeout sets how long a connection waits for a lock held by another
+// connection before returning "database is locked".
+func WithBusyTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.busyTimeout = d
+	}
+}
+
+// Close closes the prepared statements and the underlying database.
 func (s *Store) Close() error {
+	for _, st := range s.stmts.all() {
+		if *st != nil {
+			(*st).Close()
+		}
+	}
 	return s.db.Close()
 }
 
+func (ss *statements) all() map[string]**stmt {
+	return map[string]**stmt{
+		removeFoundationAddressQuery: &ss.removeFoundationAddress,
+		deleteEmptyAddressQuery:      &ss.deleteEmptyAddress,
+		insertFoundationAddressQuery: &ss.insertFoundationAddress,
+		updateBalanceQuery:           &ss.updateBalance,
+		updateImmatureQuery:          &ss.updateImmature,
+		insertHistoryQuery:           &ss.insertHistory,
+	}
+}
+
+// prepareStatements prepares the statements used to update the index. The
+// statements are safe for concurrent use.
+func (s *Store) prepareStatements() error {
+	log := s.log.Named("statement")
+	for query, st := range s.stmts.all() {
+		ps, err := s.db.Prepare(query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare %q: %w", query, err)
+		}
+		*st = &stmt{Stmt: ps, query: query, log: log}
+	}
+	return nil
+}
+
 // transaction executes a function within a database transaction. If the
 // function returns an error, the transaction is rolled back. Otherwise, the
 // transaction is committed. If the transaction fails due to a busy error, it is
@@ -64,14 +147,24 @@ func (s *Store) transaction(fn func(*txn) error) error {
 	return fmt.Errorf("transaction failed (attempt %d): %w", attempt, err)
 }
 
-func sqliteFilepath(fp string) string {
+// sqliteFilepath returns the connection string for the database at fp. WAL
+// mode allows the API to read while the indexer writes. With WAL,
+// synchronous=NORMAL is still safe from corruption, but the most recent
+// transactions may be rolled back after a power loss or OS crash. The index
+// resyncs any lost blocks from walletd, so durability is traded for faster
+// commits. An in-memory database has no journal file, so WAL does not apply.
+func sqliteFilepath(fp string, busyTimeout time.Duration) string {
 	params := []string{
-		fmt.Sprintf("_busy_timeout=%d", busyTimeout),
+		fmt.Sprintf("_busy_timeout=%d", busyTimeout.Milliseconds()),
 		"_foreign_keys=true",
-		"_journal_mode=WAL",
+	}
+	if fp != MemoryDatabase {
+		params = append(params, "_journal_mode=WAL", "_synchronous=NORMAL")
+	}
+	params = append(params,
 		"_secure_delete=false",
 		"_cache_size=-65536", // 64MiB
-	}
+	)
 	return "file:" + fp + "?" + strings.Join(params, "&")
 }
 
@@ -131,20 +224,112 @@ func integrityCheck(db *sql.DB, log *zap.Logger) error {
 }
 
 // OpenDatabase creates a new SQLite store and initializes the database. If the
-// database does not exist, it is created.
-func OpenDatabase(fp string, log *zap.Logger) (*Store, error) {
-	db, err := sql.Open("sqlite3", sqliteFilepath(fp))
+// database does not exist, it is created. If fp is MemoryDatabase, the
+// database is kept in memory and nothing is written to disk.
+func OpenDatabase(fp string, log *zap.Logger, opts ...Option) (*Store, error) {
+	store := &Store{
+		busyTimeout: defaultBusyTimeout,
+		log:         log,
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	db, err := sql.Open(driverName, sqliteFilepath(fp, store.busyTimeout))
 	if err != nil {
 		return nil, err
 	}
-	store := &Store{
-		db:  db,
-		log: log,
+	if fp == MemoryDatabase {
+		// each connection opens its own in-memory database, so all queries
+		// must share one connection. Readers wait for the indexer's
+		// transactions instead of reading concurrently.
+		db.SetMaxOpenConns(1)
 	}
+	store.db = db
 	if err := store.init(); err != nil {
+		db.Close()
+		return nil, err
+	} else if err := store.prepareStatements(); err != nil {
+		store.Close()
 		return nil, err
 	}
 	sqliteVersion, _, _ := sqlite3.Version()
 	log.Debug("database initialized", zap.String("sqliteVersion", sqliteVersion), zap.Int("schemaVersion", len(migrations)+1), zap.String("path", fp))
 	return store, nil
 }
+
+// size returns the size of the database in bytes.
+func (s *Store) size() (int64, error) {
+	var pageCount, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("failed to get page count: %w", err)
+	} else if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to get page size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+// Vacuum rebuilds the database to reclaim unused space and returns the
+// resulting size in bytes. Writes are blocked until the vacuum completes.
+func (s *Store) Vacuum() (int64, error) {
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return 0, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return s.size()
+}
+
+// Backup writes a copy of the database to path using SQLite's online backup
+// API and returns the size of the backup in bytes. The database can be
+// written to while the backup is running. The backup fails if path already
+// exists.
+func (s *Store) Backup(path string) (size int64, err error) {
+	if _, err := os.Stat(path); err == nil {
+		return 0, fmt.Errorf("backup %q already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to stat backup path: %w", err)
+	}
+
+	dest, err := sql.Open(driverName, "file:"+path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer func() {
+		dest.Close()
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get backup connection: %w", err)
+	}
+	defer destConn.Close()
+	srcConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	err = destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			backup, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main", srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			} else if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat backup: %w", err)
+	}
+	return info.Size(), nil
+}
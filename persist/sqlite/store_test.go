@@ -0,0 +1,222 @@
+package sqlite
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/cmc-supply-api/index"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap/zaptest"
+	"lukechampine.com/frand"
+)
+
+func TestPragmas(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "supply.sqlite3"), log, WithBusyTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	var synchronous, foreignKeys, busyTimeout int
+	if err := db.db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	} else if journalMode != "wal" {
+		t.Fatalf("expected WAL journal mode, got %q", journalMode)
+	} else if err := db.db.QueryRow(`PRAGMA synchronous`).Scan(&synchronous); err != nil {
+		t.Fatal(err)
+	} else if synchronous != 1 { // NORMAL
+		t.Fatalf("expected synchronous NORMAL, got %d", synchronous)
+	} else if err := db.db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		t.Fatal(err)
+	} else if foreignKeys != 1 {
+		t.Fatal("expected foreign keys to be enabled")
+	} else if err := db.db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil {
+		t.Fatal(err)
+	} else if busyTimeout != 5000 {
+		t.Fatalf("expected busy timeout 5000, got %d", busyTimeout)
+	}
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	// an in-memory database shares a single connection, so readers wait for
+	// the writer instead of reading concurrently
+	for _, path := range []string{"supply.sqlite3", MemoryDatabase} {
+		t.Run(path, func(t *testing.T) {
+			if path != MemoryDatabase {
+				path = filepath.Join(t.TempDir(), path)
+			}
+			testConcurrentReadWrite(t, path)
+		})
+	}
+}
+
+func testConcurrentReadWrite(t *testing.T, path string) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the writer stops the readers when it is done
+	done := make(chan struct{})
+	errCh := make(chan error, 5)
+	go func() {
+		defer close(done)
+		for height := uint64(0); height < 200; height++ {
+			deltas := []index.AddressDelta{{Address: frand.Entropy256(), Incoming: types.Siacoins(1)}}
+			state := index.State{Index: types.ChainIndex{Height: height, ID: frand.Entropy256()}}
+			if err := db.UpdateState(state, []index.State{state}, deltas, nil, nil, nil); err != nil {
+				errCh <- fmt.Errorf("failed to update state: %w", err)
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	read := func() error {
+		if _, _, err := db.Supply(); err != nil {
+			return fmt.Errorf("failed to get supply: %w", err)
+		} else if _, err := db.RichList(10, 0); err != nil {
+			return fmt.Errorf("failed to get rich list: %w", err)
+		} else if _, err := db.SupplyHistory(0, 200, 100); err != nil {
+			return fmt.Errorf("failed to get supply history: %w", err)
+		}
+		return nil
+	}
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errCh <- nil
+					return
+				default:
+				}
+				if err := read(); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackupVacuum(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	dir := t.TempDir()
+	db, err := OpenDatabase(filepath.Join(dir, "supply.sqlite3"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var deltas []index.AddressDelta
+	for i := 0; i < 100; i++ {
+		deltas = append(deltas, index.AddressDelta{Address: frand.Entropy256(), Incoming: types.Siacoins(1)})
+	}
+	state := index.State{
+		Index:       types.ChainIndex{Height: 10, ID: frand.Entropy256()},
+		TotalSupply: types.Siacoins(100),
+	}
+	if err := db.UpdateState(state, nil, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// spend half of the addresses so there is space to reclaim
+	for i := range deltas[:50] {
+		deltas[i].Incoming, deltas[i].Outgoing = types.ZeroCurrency, deltas[i].Incoming
+	}
+	if err := db.UpdateState(state, nil, deltas[:50], nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if size, err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	} else if size <= 0 {
+		t.Fatalf("expected positive size, got %d", size)
+	}
+
+	backupPath := filepath.Join(dir, "backup.sqlite3")
+	if size, err := db.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	} else if size <= 0 {
+		t.Fatalf("expected positive size, got %d", size)
+	} else if _, err := db.Backup(backupPath); err == nil {
+		t.Fatal("expected backup to an existing path to fail")
+	}
+
+	backup, err := OpenDatabase(backupPath, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	if backupState, err := backup.State(); err != nil {
+		t.Fatal(err)
+	} else if backupState.Index != state.Index || !backupState.TotalSupply.Equals(state.TotalSupply) {
+		t.Fatalf("expected state %+v, got %+v", state, backupState)
+	} else if balances, err := backup.RichList(100, 0); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 50 {
+		t.Fatalf("expected 50 balances, got %d", len(balances))
+	}
+}
+
+func TestMemoryDatabase(t *testing.T) {
+	log := zaptest.NewLogger(t)
+	db, err := OpenDatabase(MemoryDatabase, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	if err := db.db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	} else if journalMode != "memory" {
+		t.Fatalf("expected memory journal mode, got %q", journalMode)
+	}
+
+	// every query must see the same database
+	state := index.State{
+		Index:       types.ChainIndex{Height: 10, ID: frand.Entropy256()},
+		TotalSupply: types.Siacoins(100),
+	}
+	deltas := []index.AddressDelta{{Address: frand.Entropy256(), Incoming: types.Siacoins(100)}}
+	if err := db.UpdateState(state, []index.State{state}, deltas, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if dbState, err := db.State(); err != nil {
+		t.Fatal(err)
+	} else if dbState.Index != state.Index || !dbState.TotalSupply.Equals(state.TotalSupply) {
+		t.Fatalf("expected state %+v, got %+v", state, dbState)
+	} else if balances, err := db.RichList(10, 0); err != nil {
+		t.Fatal(err)
+	} else if len(balances) != 1 {
+		t.Fatalf("expected 1 balance, got %d", len(balances))
+	}
+
+	// an in-memory database can still be backed up to disk
+	backupPath := filepath.Join(t.TempDir(), "backup.sqlite3")
+	if _, err := db.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := OpenDatabase(backupPath, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if backupState, err := backup.State(); err != nil {
+		t.Fatal(err)
+	} else if backupState.Index != state.Index {
+		t.Fatalf("expected index %v, got %v", state.Index, backupState.Index)
+	}
+}